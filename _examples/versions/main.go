@@ -30,37 +30,23 @@ func main() {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	// API version 3.
-	r.Route("/v3", func(r chi.Router) {
-		r.Use(apiVersionCtx("v3"))
-		r.Mount("/articles", articleRouter())
-	})
-
-	// API version 2.
-	r.Route("/v2", func(r chi.Router) {
-		r.Use(apiVersionCtx("v2"))
-		r.Mount("/articles", articleRouter())
-	})
-
-	// API version 1.
-	r.Route("/v1", func(r chi.Router) {
-		r.Use(randomErrorMiddleware) // Simulate random error, ie. version 1 is buggy.
-		r.Use(apiVersionCtx("v1"))
-		r.Mount("/articles", articleRouter())
-	})
+	// API versions are resolved from the URL prefix (/v1/articles), the
+	// X-API-Version header or the Accept media-type parameter
+	// (application/vnd.acme+json;version=2). Requests without a version
+	// are served by the latest one.
+	r.Use(middleware.APIVersion(middleware.APIVersionOpts{
+		Versions: []string{"v1", "v2", "v3"},
+		Retired: map[string]middleware.APIVersionRetirement{
+			"v1": {Sunset: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}))
+	r.Use(randomErrorMiddleware) // Simulate random error, ie. version 1 is buggy.
+
+	r.Mount("/articles", articleRouter())
 
 	http.ListenAndServe(":3333", r)
 }
 
-func apiVersionCtx(version string) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			r = r.WithContext(context.WithValue(r.Context(), "api.version", version))
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
 func articleRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Get("/", listArticles)
@@ -85,11 +71,11 @@ func listArticles(w http.ResponseWriter, r *http.Request) {
 				CustomDataForAuthUsers: "secret data for auth'd users only",
 			}
 
-			apiVersion := r.Context().Value("api.version").(string)
+			apiVersion := middleware.GetAPIVersion(r.Context())
 			switch apiVersion {
-			case "v1":
+			case "1":
 				articles <- v1.NewArticleResponse(article)
-			case "v2":
+			case "2":
 				articles <- v2.NewArticleResponse(article)
 			default:
 				articles <- v3.NewArticleResponse(article)
@@ -130,11 +116,11 @@ func getArticle(w http.ResponseWriter, r *http.Request) {
 
 	var payload render.Renderer
 
-	apiVersion := r.Context().Value("api.version").(string)
+	apiVersion := middleware.GetAPIVersion(r.Context())
 	switch apiVersion {
-	case "v1":
+	case "1":
 		payload = v1.NewArticleResponse(article)
-	case "v2":
+	case "2":
 		payload = v2.NewArticleResponse(article)
 	default:
 		payload = v3.NewArticleResponse(article)
@@ -145,6 +131,11 @@ func getArticle(w http.ResponseWriter, r *http.Request) {
 
 func randomErrorMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middleware.GetAPIVersion(r.Context()) != "1" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		rand.Seed(time.Now().Unix())
 
 		// One in three chance of random error.
@@ -0,0 +1,220 @@
+// Package chitest provides utilities for testing chi routers and handlers.
+//
+// It can assert which routing pattern, handler and URL parameters a request
+// resolves to without serving it, and build requests with a pre-populated
+// chi routing context so handlers can be unit tested without a router.
+//
+// Example:
+//  func TestRoutes(t *testing.T) {
+//  	r := routes()
+//
+//  	chitest.AssertRoutes(t, r, []chitest.Route{
+//  		{Method: "GET", Path: "/articles/1", Pattern: "/articles/{id}", Params: map[string]string{"id": "1"}},
+//  		{Method: "GET", Path: "/articles/1/edit", NotFound: true},
+//  	})
+//  }
+//
+//  func TestGetArticle(t *testing.T) {
+//  	r := chitest.NewRequest("GET", "/articles/1", nil)
+//  	r = chitest.WithURLParam(r, "id", "1")
+//
+//  	w := httptest.NewRecorder()
+//  	getArticle(w, r)
+//  }
+package chitest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Match describes the routing result for a method and path.
+type Match struct {
+	// Pattern is the full routing pattern across all sub-routers, as
+	// reported by chi.Context.RoutePattern().
+	Pattern string
+
+	// Params are the URL parameters captured along the routing path.
+	Params map[string]string
+
+	// Handler is the endpoint handler, without the inline middlewares
+	// defined with With() or Group().
+	Handler http.Handler
+
+	// Middlewares are the middlewares the endpoint handler is wrapped with,
+	// including those of parent routers.
+	Middlewares chi.Middlewares
+}
+
+// Resolve searches the routing tree for the method and path without serving
+// a request, and reports what it resolves to. It returns false when no
+// route matches.
+func Resolve(r chi.Routes, method, path string) (Match, bool) {
+	rctx := chi.NewRouteContext()
+	if !r.Match(rctx, method, path) {
+		return Match{}, false
+	}
+
+	m := Match{Pattern: rctx.RoutePattern(), Params: map[string]string{}}
+	for i, k := range rctx.URLParams.Keys {
+		if i < len(rctx.URLParams.Values) {
+			m.Params[k] = rctx.URLParams.Values[i]
+		}
+	}
+	// The wildcard param is only meaningful for the final routing pattern,
+	// as each mounted sub-router reuses it along the way.
+	if !strings.HasSuffix(m.Pattern, "*") {
+		delete(m.Params, "*")
+	}
+
+	chi.Walk(r, func(wm string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if wm == method && route == m.Pattern {
+			m.Handler = handler
+			m.Middlewares = middlewares
+		}
+		return nil
+	})
+
+	return m, true
+}
+
+// Route is an expected routing result, used by AssertRoute and AssertRoutes.
+type Route struct {
+	Method string
+	Path   string
+
+	// NotFound expects no route to match the method and path.
+	NotFound bool
+
+	// Pattern is the expected full routing pattern.
+	Pattern string
+
+	// Params are the expected URL parameters. The params are not checked
+	// when nil, an empty map expects no params.
+	Params map[string]string
+
+	// Handler is the expected endpoint handler. The handler is not checked
+	// when nil. See SameHandler.
+	Handler http.Handler
+}
+
+// AssertRoute reports a test error when the method and path of the route do
+// not resolve as expected.
+func AssertRoute(t testing.TB, r chi.Routes, want Route) bool {
+	t.Helper()
+
+	m, ok := Resolve(r, want.Method, want.Path)
+	if want.NotFound {
+		if ok {
+			t.Errorf("chitest: %s %s: expecting no route, but matched '%s'", want.Method, want.Path, m.Pattern)
+			return false
+		}
+		return true
+	}
+	if !ok {
+		t.Errorf("chitest: %s %s: expecting route '%s', but nothing matched", want.Method, want.Path, want.Pattern)
+		return false
+	}
+
+	pass := true
+	if m.Pattern != want.Pattern {
+		t.Errorf("chitest: %s %s: expecting route '%s', but matched '%s'", want.Method, want.Path, want.Pattern, m.Pattern)
+		pass = false
+	}
+	if want.Params != nil && !reflect.DeepEqual(m.Params, want.Params) {
+		t.Errorf("chitest: %s %s: expecting params %s, but got %s", want.Method, want.Path, formatParams(want.Params), formatParams(m.Params))
+		pass = false
+	}
+	if want.Handler != nil && !SameHandler(m.Handler, want.Handler) {
+		t.Errorf("chitest: %s %s: route '%s' resolved to a different handler", want.Method, want.Path, m.Pattern)
+		pass = false
+	}
+	return pass
+}
+
+// AssertRoutes checks a table of expected routing results. Each route is
+// reported as a separate error, so the whole table is checked at once.
+func AssertRoutes(t testing.TB, r chi.Routes, want []Route) bool {
+	t.Helper()
+
+	pass := true
+	for _, w := range want {
+		if !AssertRoute(t, r, w) {
+			pass = false
+		}
+	}
+	return pass
+}
+
+// SameHandler reports whether two handlers are the same. Handler functions
+// are compared by their code pointer, as Go funcs are not comparable.
+func SameHandler(a, b http.Handler) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Kind() == reflect.Func && vb.Kind() == reflect.Func {
+		return va.Pointer() == vb.Pointer()
+	}
+	if va.Type() != vb.Type() || !va.Type().Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// NewRequest returns a new incoming server request, like httptest.NewRequest,
+// with an empty chi routing context. Use WithURLParam and WithRoutePattern to
+// populate the routing context before passing the request to a handler.
+func NewRequest(method, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, chi.NewRouteContext()))
+}
+
+// WithURLParam adds a URL parameter to the chi routing context of the
+// request, so it's available to chi.URLParam. A routing context is added to
+// the request if it has none.
+func WithURLParam(r *http.Request, key, value string) *http.Request {
+	r, rctx := routeContext(r)
+	rctx.URLParams.Add(key, value)
+	return r
+}
+
+// WithRoutePattern appends a routing pattern to the chi routing context of
+// the request, as a router would while routing the request. A routing context
+// is added to the request if it has none.
+func WithRoutePattern(r *http.Request, pattern string) *http.Request {
+	r, rctx := routeContext(r)
+	rctx.RoutePatterns = append(rctx.RoutePatterns, pattern)
+	return r
+}
+
+func routeContext(r *http.Request) (*http.Request, *chi.Context) {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		rctx = chi.NewRouteContext()
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	}
+	return r, rctx
+}
+
+func formatParams(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + params[k]
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,85 @@
+package chitest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAssertRoutes(t *testing.T) {
+	listArticles := func(w http.ResponseWriter, r *http.Request) {}
+	getArticle := func(w http.ResponseWriter, r *http.Request) {}
+	getFile := func(w http.ResponseWriter, r *http.Request) {}
+	mw := func(next http.Handler) http.Handler { return next }
+
+	r := chi.NewRouter()
+	r.Route("/articles", func(r chi.Router) {
+		r.Get("/", listArticles)
+		r.With(mw).Get("/{id}", getArticle)
+	})
+	r.Get("/files/*", getFile)
+
+	AssertRoutes(t, r, []Route{
+		{Method: "GET", Path: "/articles", Pattern: "/articles/", Params: map[string]string{}, Handler: http.HandlerFunc(listArticles)},
+		{Method: "GET", Path: "/articles/1", Pattern: "/articles/{id}", Params: map[string]string{"id": "1"}, Handler: http.HandlerFunc(getArticle)},
+		{Method: "GET", Path: "/files/a/b.txt", Pattern: "/files/*", Params: map[string]string{"*": "a/b.txt"}},
+		{Method: "POST", Path: "/articles/1", NotFound: true},
+		{Method: "GET", Path: "/users", NotFound: true},
+	})
+
+	m, ok := Resolve(r, "GET", "/articles/1")
+	if !ok || len(m.Middlewares) != 1 {
+		t.Fatalf("expecting the inline middleware to be reported, got %d", len(m.Middlewares))
+	}
+
+	// Failures are reported to the test.
+	rt := &recordingT{TB: t}
+	if AssertRoute(rt, r, Route{Method: "GET", Path: "/articles/1", Pattern: "/articles/{slug}", Params: map[string]string{"id": "2"}, Handler: http.HandlerFunc(listArticles)}) {
+		t.Fatal("expecting assertion to fail")
+	}
+	if len(rt.errors) != 3 {
+		t.Fatalf("expecting 3 errors, got %d: %v", len(rt.errors), rt.errors)
+	}
+	if !strings.Contains(rt.errors[1], "{id=2}") || !strings.Contains(rt.errors[1], "{id=1}") {
+		t.Fatalf("unexpected params error: %s", rt.errors[1])
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.RouteContext(r.Context())
+		w.Write([]byte(chi.URLParam(r, "id") + " " + rctx.RoutePattern()))
+	}
+
+	r := NewRequest("GET", "/articles/1", nil)
+	r = WithURLParam(r, "id", "1")
+	r = WithRoutePattern(r, "/articles/{id}")
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Body.String() != "1 /articles/{id}" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+
+	// A routing context is added to plain requests.
+	r = WithURLParam(httptest.NewRequest("GET", "/", nil), "id", "2")
+	if chi.URLParam(r, "id") != "2" {
+		t.Fatal("expecting URL param on a plain request")
+	}
+}
+
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,202 @@
+package chitest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Coverage records which routes of a router are hit by requests, to find
+// the routes a test suite never exercises.
+//
+// Example:
+//  var cov *chitest.Coverage
+//
+//  func TestMain(m *testing.M) {
+//  	r := routes()
+//  	cov = chitest.NewCoverage(r)
+//  	ts = httptest.NewServer(cov.Handler(r))
+//
+//  	code := m.Run()
+//  	ts.Close()
+//
+//  	cov.Report().WriteText(os.Stdout)
+//  	if err := cov.Check(80); err != nil && code == 0 {
+//  		fmt.Println(err)
+//  		code = 1
+//  	}
+//  	os.Exit(code)
+//  }
+type Coverage struct {
+	routes chi.Routes
+
+	mu   sync.Mutex
+	hits map[routeKey]int
+}
+
+type routeKey struct {
+	method, pattern string
+}
+
+// NewCoverage returns a Coverage recorder for the routes of r.
+func NewCoverage(r chi.Routes) *Coverage {
+	return &Coverage{routes: r, hits: map[routeKey]int{}}
+}
+
+// Handler records a hit for the method and full routing pattern of every
+// request served by next. It can either wrap a router, or be used as the
+// first middleware of the router.
+func (c *Coverage) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Provide the routing context when wrapping a router, so the routing
+		// pattern can be read once the request is done.
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil {
+			rctx = chi.NewRouteContext()
+			rctx.Routes = c.routes
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		}
+
+		next.ServeHTTP(w, r)
+
+		method := rctx.RouteMethod
+		if method == "" {
+			method = r.Method
+		}
+		c.Hit(method, rctx.RoutePattern())
+	})
+}
+
+// Hit records a hit for a method and full routing pattern.
+func (c *Coverage) Hit(method, pattern string) {
+	if pattern == "" {
+		return
+	}
+	c.mu.Lock()
+	c.hits[routeKey{method, pattern}]++
+	c.mu.Unlock()
+}
+
+// Report builds a coverage report of all the routes of the router, as
+// visited by chi.Walk. Routes defined for all methods are reported once for
+// each method.
+func (c *Coverage) Report() CoverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := CoverageReport{}
+	chi.Walk(c.routes, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		pattern := normalizePattern(route)
+		hits := c.hits[routeKey{method, pattern}]
+		report.Routes = append(report.Routes, RouteCoverage{Method: method, Pattern: pattern, Hits: hits})
+		report.Total++
+		if hits > 0 {
+			report.Hit++
+		}
+		return nil
+	})
+
+	sort.Slice(report.Routes, func(i, j int) bool {
+		a, b := report.Routes[i], report.Routes[j]
+		if a.Pattern != b.Pattern {
+			return a.Pattern < b.Pattern
+		}
+		return a.Method < b.Method
+	})
+	if report.Total > 0 {
+		report.Percent = float64(report.Hit) * 100 / float64(report.Total)
+	}
+	return report
+}
+
+// Check returns an error when the percentage of routes hit is below the
+// threshold, listing the routes that were never hit.
+func (c *Coverage) Check(threshold float64) error {
+	report := c.Report()
+	if report.Percent >= threshold {
+		return nil
+	}
+	unhit := report.Unhit()
+	routes := make([]string, len(unhit))
+	for i, rt := range unhit {
+		routes[i] = rt.Method + " " + rt.Pattern
+	}
+	return fmt.Errorf("chitest: route coverage %.1f%% is below %.1f%%, unhit routes: %s",
+		report.Percent, threshold, strings.Join(routes, ", "))
+}
+
+// Assert reports a test error when the percentage of routes hit is below
+// the threshold.
+func (c *Coverage) Assert(t testing.TB, threshold float64) bool {
+	t.Helper()
+	if err := c.Check(threshold); err != nil {
+		t.Error(err)
+		return false
+	}
+	return true
+}
+
+// CoverageReport is the route coverage of a router.
+type CoverageReport struct {
+	Total   int             `json:"total"`
+	Hit     int             `json:"hit"`
+	Percent float64         `json:"percent"`
+	Routes  []RouteCoverage `json:"routes"`
+}
+
+// RouteCoverage is the number of hits of a method and full routing pattern.
+type RouteCoverage struct {
+	Method  string `json:"method"`
+	Pattern string `json:"pattern"`
+	Hits    int    `json:"hits"`
+}
+
+// Unhit returns the routes that were never hit.
+func (r CoverageReport) Unhit() []RouteCoverage {
+	unhit := []RouteCoverage{}
+	for _, rt := range r.Routes {
+		if rt.Hits == 0 {
+			unhit = append(unhit, rt)
+		}
+	}
+	return unhit
+}
+
+// WriteText writes a human readable report, listing the routes that were
+// never hit.
+func (r CoverageReport) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "route coverage: %.1f%% (%d/%d routes)\n", r.Percent, r.Hit, r.Total)
+	if err != nil {
+		return err
+	}
+	for _, rt := range r.Unhit() {
+		if _, err := fmt.Fprintf(w, "  unhit: %-7s %s\n", rt.Method, rt.Pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the report as a JSON document.
+func (r CoverageReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// normalizePattern resolves a route visited by chi.Walk the same way as
+// chi.Context.RoutePattern() resolves the pattern of a request.
+func normalizePattern(p string) string {
+	for strings.Contains(p, "/*/") {
+		p = strings.Replace(p, "/*/", "/", -1)
+	}
+	return p
+}
@@ -0,0 +1,85 @@
+package chitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCoverage(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := chi.NewRouter()
+	r.Get("/", h)
+	r.Route("/articles", func(r chi.Router) {
+		r.Get("/", h)
+		r.Get("/{id}", h)
+		r.Delete("/{id}", h)
+	})
+
+	cov := NewCoverage(r)
+	ts := httptest.NewServer(cov.Handler(r))
+	defer ts.Close()
+
+	for _, path := range []string{"/", "/articles/1", "/articles/2", "/missing"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	report := cov.Report()
+	if report.Total != 4 || report.Hit != 2 || report.Percent != 50 {
+		t.Fatalf("unexpected report totals: %+v", report)
+	}
+	for _, rt := range report.Routes {
+		if rt.Method == "GET" && rt.Pattern == "/articles/{id}" && rt.Hits != 2 {
+			t.Fatalf("expecting 2 hits on GET /articles/{id}, got %d", rt.Hits)
+		}
+	}
+
+	unhit := report.Unhit()
+	if len(unhit) != 2 || unhit[0].Pattern != "/articles/" || unhit[1].Method != "DELETE" {
+		t.Fatalf("unexpected unhit routes: %+v", unhit)
+	}
+
+	buf := &bytes.Buffer{}
+	report.WriteText(buf)
+	if !strings.Contains(buf.String(), "route coverage: 50.0% (2/4 routes)") ||
+		!strings.Contains(buf.String(), "DELETE  /articles/{id}") {
+		t.Fatalf("unexpected text report:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	report.WriteJSON(buf)
+	var decoded CoverageReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded.Hit != 2 {
+		t.Fatalf("unexpected json report: %s", buf.String())
+	}
+
+	if err := cov.Check(50); err != nil {
+		t.Fatal(err)
+	}
+	if err := cov.Check(75); err == nil || !strings.Contains(err.Error(), "GET /articles/") {
+		t.Fatalf("expecting threshold error, got %v", err)
+	}
+}
+
+func TestCoverageMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	cov := NewCoverage(r)
+	r.Use(cov.Handler)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/1", nil))
+
+	if err := cov.Check(100); err != nil {
+		t.Fatal(err)
+	}
+}
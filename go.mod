@@ -1,3 +1,5 @@
 module github.com/go-chi/chi/v5
 
 go 1.14
+
+require github.com/go-chi/render v1.0.3
@@ -0,0 +1,320 @@
+package middleware
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	// APIVersionCtxKey is the context.Context key to store the resolved API
+	// version for a request.
+	APIVersionCtxKey = &contextKey{"APIVersion"}
+)
+
+// APIVersionStrategy extracts the requested API version from a request. It
+// returns false when the request does not carry a version for the strategy.
+type APIVersionStrategy func(r *http.Request) (string, bool)
+
+// APIVersionRetirement describes the retirement schedule of an API version.
+// Requests resolved to a retired version are answered with the Deprecation
+// and Sunset response headers.
+type APIVersionRetirement struct {
+	// Deprecation is the point in time the version was deprecated. A zero
+	// value marks the version as deprecated without a date.
+	Deprecation time.Time
+
+	// Sunset is the point in time the version will stop being served.
+	Sunset time.Time
+
+	// Link optionally points to documentation about the retirement.
+	Link string
+}
+
+// APIVersionOpts represents a set of API versioning options.
+type APIVersionOpts struct {
+	// Versions is the list of supported versions, such as "1", "2" or "2.1". A
+	// leading "v" is ignored, so "v2" and "2" are the same version.
+	Versions []string
+
+	// Default is the version used when no strategy resolves a version. If
+	// empty, the latest supported version is used.
+	Default string
+
+	// Strategies are consulted in order, the first one to report a version
+	// wins. Defaults to APIVersionFromPath, APIVersionFromHeader("X-API-Version")
+	// and APIVersionFromMediaType("version").
+	Strategies []APIVersionStrategy
+
+	// Retired lists the versions that are deprecated or scheduled for sunset.
+	Retired map[string]APIVersionRetirement
+}
+
+// APIVersion is a middleware that resolves the API version of a request from
+// the URL path prefix, a request header or the Accept media-type parameter,
+// and stores it on the context under the key `middleware.APIVersionCtxKey`.
+//
+// When the requested version is not supported, the nearest lower supported
+// version is used. Requests for a version older than any supported version
+// are responded with a 400 Bad Request.
+//
+// Sample usage.. for url paths: `/v1/articles`, `/v2/articles` or `/articles`
+// with the `Accept: application/vnd.acme+json;version=2` header:
+//
+//  r.Use(middleware.APIVersion(middleware.APIVersionOpts{
+//    Versions: []string{"1", "2", "3"},
+//    Retired: map[string]middleware.APIVersionRetirement{
+//      "1": {Sunset: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+//    },
+//  }))
+//  r.Mount("/articles", articleRouter())
+//
+//  func listArticles(w http.ResponseWriter, r *http.Request) {
+//    switch middleware.GetAPIVersion(r.Context()) {
+//    case "1":
+//      ...
+//    }
+//  }
+func APIVersion(opts APIVersionOpts) func(next http.Handler) http.Handler {
+	if len(opts.Versions) == 0 {
+		panic("chi/middleware: APIVersion expects at least one supported version")
+	}
+
+	versions := make([]apiVersion, 0, len(opts.Versions))
+	for _, v := range opts.Versions {
+		av, ok := parseAPIVersion(v)
+		if !ok {
+			panic("chi/middleware: APIVersion invalid version '" + v + "'")
+		}
+		versions = append(versions, av)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].less(versions[j])
+	})
+
+	defaultVersion := versions[len(versions)-1]
+	if opts.Default != "" {
+		av, ok := parseAPIVersion(opts.Default)
+		if !ok {
+			panic("chi/middleware: APIVersion invalid default version '" + opts.Default + "'")
+		}
+		defaultVersion, ok = findAPIVersion(versions, av)
+		if !ok {
+			panic("chi/middleware: APIVersion default version '" + opts.Default + "' is not a supported version")
+		}
+	}
+
+	strategies := opts.Strategies
+	if len(strategies) == 0 {
+		strategies = []APIVersionStrategy{
+			APIVersionFromPath,
+			APIVersionFromHeader("X-API-Version"),
+			APIVersionFromMediaType("version"),
+		}
+	}
+	// Functions can't be compared, so APIVersionFromPath is told apart by its
+	// code pointer.
+	stripPath := false
+	for _, strategy := range strategies {
+		if reflect.ValueOf(strategy).Pointer() == reflect.ValueOf(APIVersionFromPath).Pointer() {
+			stripPath = true
+		}
+	}
+
+	retired := make(map[string]APIVersionRetirement, len(opts.Retired))
+	for v, rt := range opts.Retired {
+		av, ok := parseAPIVersion(v)
+		if !ok {
+			panic("chi/middleware: APIVersion invalid retired version '" + v + "'")
+		}
+		retired[av.name] = rt
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			resolved := defaultVersion
+			for _, strategy := range strategies {
+				v, ok := strategy(r)
+				if !ok {
+					continue
+				}
+				requested, ok := parseAPIVersion(v)
+				if !ok {
+					http.Error(w, "invalid API version", http.StatusBadRequest)
+					return
+				}
+				resolved, ok = nearestAPIVersion(versions, requested)
+				if !ok {
+					http.Error(w, "unsupported API version", http.StatusBadRequest)
+					return
+				}
+				break
+			}
+
+			if rt, ok := retired[resolved.name]; ok {
+				if rt.Deprecation.IsZero() {
+					w.Header().Set("Deprecation", "true")
+				} else {
+					w.Header().Set("Deprecation", "@"+strconv.FormatInt(rt.Deprecation.Unix(), 10))
+				}
+				if !rt.Sunset.IsZero() {
+					w.Header().Set("Sunset", rt.Sunset.UTC().Format(http.TimeFormat))
+				}
+				if rt.Link != "" {
+					w.Header().Add("Link", "<"+rt.Link+`>; rel="deprecation"`)
+				}
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), APIVersionCtxKey, resolved.name))
+			if stripPath {
+				// Routing continues as though the version prefix was never
+				// there, whichever strategy resolved the version.
+				if _, rest, ok := apiVersionPathPrefix(r); ok {
+					if rctx := chi.RouteContext(r.Context()); rctx != nil {
+						rctx.RoutePath = rest
+					} else {
+						u := *r.URL
+						u.Path, u.RawPath = rest, ""
+						r.URL = &u
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetAPIVersion returns the resolved API version from the given context, or
+// the empty string if the APIVersion middleware did not run.
+func GetAPIVersion(ctx context.Context) string {
+	v, _ := ctx.Value(APIVersionCtxKey).(string)
+	return v
+}
+
+// APIVersionFromPath resolves the version from a "/v{version}" prefix of the
+// routing path. The APIVersion middleware trims the prefix from the routing
+// path when this strategy is used, so routing continues as though the prefix
+// was never there.
+func APIVersionFromPath(r *http.Request) (string, bool) {
+	v, _, ok := apiVersionPathPrefix(r)
+	return v, ok
+}
+
+// apiVersionPathPrefix splits the "/v{version}" prefix of the routing path
+// from the rest of the path.
+func apiVersionPathPrefix(r *http.Request) (string, string, bool) {
+	path := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+		path = rctx.RoutePath
+	}
+
+	if len(path) < 3 || path[0] != '/' || (path[1] != 'v' && path[1] != 'V') {
+		return "", "", false
+	}
+	segment := path[1:]
+	rest := "/"
+	if idx := strings.IndexByte(segment, '/'); idx >= 0 {
+		rest = segment[idx:]
+		segment = segment[:idx]
+	}
+	if _, ok := parseAPIVersion(segment); !ok {
+		return "", "", false
+	}
+	return segment, rest, true
+}
+
+// APIVersionFromHeader resolves the version from the given request header,
+// for example "X-API-Version: 2".
+func APIVersionFromHeader(header string) APIVersionStrategy {
+	return func(r *http.Request) (string, bool) {
+		v := strings.TrimSpace(r.Header.Get(header))
+		return v, v != ""
+	}
+}
+
+// APIVersionFromMediaType resolves the version from a parameter of the Accept
+// header media types, as in "Accept: application/vnd.acme+json;version=2".
+func APIVersionFromMediaType(param string) APIVersionStrategy {
+	return func(r *http.Request) (string, bool) {
+		for _, accept := range r.Header.Values("Accept") {
+			for _, mt := range strings.Split(accept, ",") {
+				_, params, err := mime.ParseMediaType(strings.TrimSpace(mt))
+				if err != nil {
+					continue
+				}
+				if v := params[param]; v != "" {
+					return v, true
+				}
+			}
+		}
+		return "", false
+	}
+}
+
+// apiVersion is a parsed dot-separated numeric version (e.g., "2.1").
+type apiVersion struct {
+	name  string
+	parts []int
+}
+
+func parseAPIVersion(s string) (apiVersion, bool) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "v"), "V")
+	if s == "" {
+		return apiVersion{}, false
+	}
+	fields := strings.Split(s, ".")
+	parts := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return apiVersion{}, false
+		}
+		parts[i] = n
+	}
+	return apiVersion{name: s, parts: parts}, true
+}
+
+func (v apiVersion) less(o apiVersion) bool {
+	for i := 0; i < len(v.parts) || i < len(o.parts); i++ {
+		var a, b int
+		if i < len(v.parts) {
+			a = v.parts[i]
+		}
+		if i < len(o.parts) {
+			b = o.parts[i]
+		}
+		if a != b {
+			return a < b
+		}
+	}
+	return false
+}
+
+// findAPIVersion returns the supported version equal to the given version.
+func findAPIVersion(versions []apiVersion, v apiVersion) (apiVersion, bool) {
+	for _, sv := range versions {
+		if !sv.less(v) && !v.less(sv) {
+			return sv, true
+		}
+	}
+	return apiVersion{}, false
+}
+
+// nearestAPIVersion returns the highest version in the sorted list of
+// supported versions that is not greater than the requested version.
+func nearestAPIVersion(versions []apiVersion, requested apiVersion) (apiVersion, bool) {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !requested.less(versions[i]) {
+			return versions[i], true
+		}
+	}
+	return apiVersion{}, false
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAPIVersion(t *testing.T) {
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := chi.NewRouter()
+	r.Use(APIVersion(APIVersionOpts{
+		Versions: []string{"v1", "v2", "v3"},
+		Default:  "2",
+		Retired: map[string]APIVersionRetirement{
+			"v1": {Sunset: sunset},
+		},
+	}))
+	r.Get("/articles", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v" + GetAPIVersion(r.Context())))
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		header  http.Header
+		status  int
+		version string
+	}{
+		{"default", "/articles", nil, 200, "v2"},
+		{"path prefix", "/v3/articles", nil, 200, "v3"},
+		{"path prefix nearest lower", "/v7/articles", nil, 200, "v3"},
+		{"header", "/articles", http.Header{"X-Api-Version": {"1"}}, 200, "v1"},
+		{"media type", "/articles", http.Header{"Accept": {"application/vnd.acme+json;version=2.5"}}, 200, "v2"},
+		{"path before header", "/v1/articles", http.Header{"X-Api-Version": {"3"}}, 200, "v1"},
+		{"too old", "/v0/articles", nil, 400, ""},
+		{"invalid header", "/articles", http.Header{"X-Api-Version": {"latest"}}, 400, ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Fatalf("%s: expecting status %d but got %d", tt.name, tt.status, w.Code)
+		}
+		if tt.status != 200 {
+			continue
+		}
+		if w.Body.String() != tt.version {
+			t.Fatalf("%s: expecting version '%s' but got '%s'", tt.name, tt.version, w.Body.String())
+		}
+
+		deprecated := w.Header().Get("Deprecation") != ""
+		if deprecated != (tt.version == "v1") {
+			t.Fatalf("%s: unexpected Deprecation header '%s'", tt.name, w.Header().Get("Deprecation"))
+		}
+		if deprecated && w.Header().Get("Sunset") != sunset.Format(http.TimeFormat) {
+			t.Fatalf("%s: unexpected Sunset header '%s'", tt.name, w.Header().Get("Sunset"))
+		}
+	}
+}
+
+func TestAPIVersionStrategyOrder(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(APIVersion(APIVersionOpts{
+		Versions:   []string{"1", "2"},
+		Strategies: []APIVersionStrategy{APIVersionFromHeader("X-API-Version"), APIVersionFromPath},
+	}))
+	r.Get("/articles", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v" + GetAPIVersion(r.Context())))
+	})
+
+	req := httptest.NewRequest("GET", "/v2/articles", nil)
+	req.Header.Set("X-API-Version", "1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assertEqual(t, http.StatusOK, w.Code)
+	assertEqual(t, "v1", w.Body.String())
+}
+
+func TestAPIVersionWithoutRouter(t *testing.T) {
+	var path string
+	handler := APIVersion(APIVersionOpts{Versions: []string{"1", "2"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/articles", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assertEqual(t, "/articles", path)
+	assertEqual(t, "/v1/articles", req.URL.Path)
+
+	// Without APIVersionFromPath, the path is left alone.
+	handler = APIVersion(APIVersionOpts{
+		Versions:   []string{"1", "2"},
+		Strategies: []APIVersionStrategy{APIVersionFromHeader("X-API-Version")},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/articles", nil))
+	assertEqual(t, "/v1/articles", path)
+}
+
+func TestAPIVersionUnsupportedDefault(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expecting a panic for an unsupported default version")
+		}
+	}()
+	APIVersion(APIVersionOpts{Versions: []string{"1", "2"}, Default: "3"})
+}
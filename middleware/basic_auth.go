@@ -1,33 +1,313 @@
 package middleware
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha1"
 	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// BasicAuthUserCtxKey is the context.Context key to store the username
+	// authenticated by the BasicAuth middleware.
+	BasicAuthUserCtxKey = &contextKey{"BasicAuthUser"}
 )
 
 // BasicAuth implements a simple middleware handler for adding basic http auth to a route.
 func BasicAuth(realm string, creds map[string]string) func(next http.Handler) http.Handler {
+	return BasicAuthWithOpts(BasicAuthOpts{
+		Realm: realm,
+		Verifier: BasicAuthVerifierFunc(func(user, pass string) bool {
+			credPass, credUserOk := creds[user]
+			return credUserOk && subtle.ConstantTimeCompare([]byte(pass), []byte(credPass)) == 1
+		}),
+	})
+}
+
+// BasicAuthVerifier verifies the credentials of basic http auth.
+type BasicAuthVerifier interface {
+	Verify(user, pass string) bool
+}
+
+// BasicAuthVerifierFunc is an adapter to use a function as a BasicAuthVerifier.
+type BasicAuthVerifierFunc func(user, pass string) bool
+
+// Verify calls f(user, pass).
+func (f BasicAuthVerifierFunc) Verify(user, pass string) bool {
+	return f(user, pass)
+}
+
+// BasicAuthOpts represents a set of basic http auth options.
+type BasicAuthOpts struct {
+	Realm string
+
+	// Verifier verifies the credentials, such as a Htpasswd file.
+	Verifier BasicAuthVerifier
+
+	// MaxFailures is the number of failed attempts after which a user, or a
+	// client IP, is locked out for LockoutDuration. Zero disables lockouts.
+	MaxFailures int
+
+	// LockoutDuration is how long users and client IPs are locked out, and
+	// how long failed attempts are remembered. Defaults to 5 minutes.
+	LockoutDuration time.Duration
+}
+
+// BasicAuthWithOpts is a middleware that authenticates requests with basic
+// http auth using passed BasicAuthOpts. The authenticated username is stored
+// in the request context, see GetBasicAuthUser.
+//
+// When MaxFailures is set, users and client IPs with too many failed attempts
+// are responded with a 429 Too Many Requests until their lockout ends, to
+// slow down brute force attacks. Use it after the RealIP middleware when
+// running behind a reverse proxy.
+func BasicAuthWithOpts(opts BasicAuthOpts) func(next http.Handler) http.Handler {
+	if opts.Verifier == nil {
+		panic("chi/middleware: BasicAuth expects a verifier")
+	}
+	if opts.LockoutDuration <= 0 {
+		opts.LockoutDuration = 5 * time.Minute
+	}
+	var lockout *basicAuthLockout
+	if opts.MaxFailures > 0 {
+		lockout = &basicAuthLockout{
+			max:      opts.MaxFailures,
+			duration: opts.LockoutDuration,
+			entries:  make(map[string]*basicAuthFailures),
+			now:      time.Now,
+		}
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user, pass, ok := r.BasicAuth()
 			if !ok {
-				basicAuthFailed(w, realm)
+				basicAuthFailed(w, opts.Realm)
 				return
 			}
 
-			credPass, credUserOk := creds[user]
-			if !credUserOk || subtle.ConstantTimeCompare([]byte(pass), []byte(credPass)) != 1 {
-				basicAuthFailed(w, realm)
+			keys := []string{"user:" + user, "ip:" + KeyByIP(r)}
+			if lockout != nil {
+				if wait := lockout.locked(keys); wait > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+					http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			if !opts.Verifier.Verify(user, pass) {
+				if lockout != nil {
+					lockout.fail(keys)
+				}
+				basicAuthFailed(w, opts.Realm)
 				return
 			}
+			if lockout != nil {
+				lockout.reset(keys)
+			}
 
-			next.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), BasicAuthUserCtxKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// GetBasicAuthUser returns the username authenticated by the BasicAuth
+// middleware.
+func GetBasicAuthUser(ctx context.Context) string {
+	if user, ok := ctx.Value(BasicAuthUserCtxKey).(string); ok {
+		return user
+	}
+	return ""
+}
+
 func basicAuthFailed(w http.ResponseWriter, realm string) {
 	w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, realm))
 	w.WriteHeader(http.StatusUnauthorized)
 }
+
+// basicAuthLockout counts the failed attempts of users and client IPs.
+type basicAuthLockout struct {
+	max      int
+	duration time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*basicAuthFailures
+	ops     int
+}
+
+type basicAuthFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// locked returns how long any of the keys remains locked out.
+func (l *basicAuthLockout) locked(keys []string) time.Duration {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if e, ok := l.entries[key]; ok && now.Before(e.lockedUntil) {
+			if d := e.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+func (l *basicAuthLockout) fail(keys []string) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.ops++
+	if l.ops%1024 == 0 {
+		for k, e := range l.entries {
+			if now.Sub(e.last) > l.duration && !now.Before(e.lockedUntil) {
+				delete(l.entries, k)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		e, ok := l.entries[key]
+		if !ok || now.Sub(e.last) > l.duration {
+			e = &basicAuthFailures{}
+			l.entries[key] = e
+		}
+		e.count++
+		e.last = now
+		if e.count >= l.max {
+			e.count = 0
+			e.lockedUntil = now.Add(l.duration)
+		}
+	}
+}
+
+func (l *basicAuthLockout) reset(keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+}
+
+// HtpasswdHashFunc reports whether a password matches a hashed htpasswd entry.
+type HtpasswdHashFunc func(hash, pass string) bool
+
+// Htpasswd is a BasicAuthVerifier of the hashed credentials of an htpasswd
+// file. It supports SHA-1 ("{SHA}") and salted SHA-1 ("{SSHA}") entries, and
+// more hash schemes can be added with SetHash.
+type Htpasswd struct {
+	entries map[string]string
+	hashes  map[string]HtpasswdHashFunc
+}
+
+// ParseHtpasswd parses htpasswd formatted "user:hash" lines. Blank lines and
+// lines starting with '#' are ignored.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{
+		entries: make(map[string]string),
+		hashes:  make(map[string]HtpasswdHashFunc),
+	}
+	h.SetHash("{SHA}", htpasswdSHA)
+	h.SetHash("{SSHA}", htpasswdSSHA)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("chi/middleware: malformed htpasswd line %d", n)
+		}
+		h.entries[line[:i]] = line[i+1:]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// LoadHtpasswdFile reads and parses an htpasswd file. See ParseHtpasswd.
+func LoadHtpasswdFile(filename string) (*Htpasswd, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
+
+// SetHash sets the verification function of the entries with the prefix.
+//
+// For example, add bcrypt entries:
+//
+//  import "golang.org/x/crypto/bcrypt"
+//
+//  bcryptHash := func(hash, pass string) bool {
+//    return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+//  }
+//  htpasswd.SetHash("$2y$", bcryptHash)
+//  htpasswd.SetHash("$2a$", bcryptHash)
+func (h *Htpasswd) SetHash(prefix string, fn HtpasswdHashFunc) {
+	if prefix == "" {
+		panic("the hash prefix can not be empty")
+	}
+	if fn == nil {
+		panic("attempted to set a nil hash function")
+	}
+	h.hashes[prefix] = fn
+}
+
+// Verify reports whether the password matches the entry of the user. Entries
+// of unknown hash schemes never match.
+func (h *Htpasswd) Verify(user, pass string) bool {
+	hash, ok := h.entries[user]
+	if !ok {
+		return false
+	}
+	// The longest matching prefix wins, so "$2y$" is preferred over "$2".
+	var match HtpasswdHashFunc
+	matchLen := 0
+	for prefix, fn := range h.hashes {
+		if len(prefix) > matchLen && strings.HasPrefix(hash, prefix) {
+			match, matchLen = fn, len(prefix)
+		}
+	}
+	return match != nil && match(hash, pass)
+}
+
+func htpasswdSHA(hash, pass string) bool {
+	sum := sha1.Sum([]byte(pass))
+	want := base64.StdEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(hash, "{SHA}")), []byte(want)) == 1
+}
+
+func htpasswdSSHA(hash, pass string) bool {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "{SSHA}"))
+	if err != nil || len(b) <= sha1.Size {
+		return false
+	}
+	digest, salt := b[:sha1.Size], b[sha1.Size:]
+	sum := sha1.Sum(append([]byte(pass), salt...))
+	return subtle.ConstantTimeCompare(digest, sum[:]) == 1
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestBasicAuth(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(BasicAuth("test", map[string]string{"peter": "secret"}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetBasicAuthUser(r.Context())))
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assertEqual(t, 401, w.Code)
+	assertEqual(t, `Basic realm="test"`, w.Header().Get("WWW-Authenticate"))
+
+	req.SetBasicAuth("peter", "wrong")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assertEqual(t, 401, w.Code)
+
+	req.SetBasicAuth("peter", "secret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assertEqual(t, 200, w.Code)
+	assertEqual(t, "peter", w.Body.String())
+}
+
+func TestBasicAuthLockout(t *testing.T) {
+	mw := BasicAuthWithOpts(BasicAuthOpts{
+		Realm:           "test",
+		Verifier:        BasicAuthVerifierFunc(func(user, pass string) bool { return pass == "secret" }),
+		MaxFailures:     3,
+		LockoutDuration: time.Minute,
+	})
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(user, pass, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.SetBasicAuth(user, pass)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		assertEqual(t, 401, do("peter", "wrong", "10.0.0.1").Code)
+	}
+
+	// Both the user and the client IP are locked out, even with the right
+	// password.
+	w := do("peter", "secret", "10.0.0.2")
+	assertEqual(t, 429, w.Code)
+	assertEqual(t, "60", w.Header().Get("Retry-After"))
+	assertEqual(t, 429, do("paul", "secret", "10.0.0.1").Code)
+	assertEqual(t, 200, do("paul", "secret", "10.0.0.2").Code)
+}
+
+func TestHtpasswd(t *testing.T) {
+	sha := sha1.Sum([]byte("secret"))
+	salted := sha1.Sum([]byte("secretsalt"))
+	htpasswd, err := ParseHtpasswd(strings.NewReader(strings.Join([]string{
+		"# users",
+		"peter:{SHA}" + base64.StdEncoding.EncodeToString(sha[:]),
+		"paul:{SSHA}" + base64.StdEncoding.EncodeToString(append(salted[:], "salt"...)),
+		"mary:$custom$secret",
+		"john:$apr1$unsupported",
+		"",
+	}, "\n")))
+	assertNoError(t, err)
+	htpasswd.SetHash("$custom$", func(hash, pass string) bool {
+		return hash == "$custom$"+pass
+	})
+
+	tests := []struct {
+		user, pass string
+		ok         bool
+	}{
+		{"peter", "secret", true},
+		{"peter", "wrong", false},
+		{"paul", "secret", true},
+		{"paul", "salt", false},
+		{"mary", "secret", true},
+		{"john", "unsupported", false},
+		{"nobody", "secret", false},
+	}
+	for _, tc := range tests {
+		if got := htpasswd.Verify(tc.user, tc.pass); got != tc.ok {
+			t.Errorf("%s:%s: expected %v, got %v", tc.user, tc.pass, tc.ok, got)
+		}
+	}
+
+	if _, err := ParseHtpasswd(strings.NewReader("malformed")); err == nil {
+		t.Fatal("expecting an error for a malformed line")
+	}
+}
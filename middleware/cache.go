@@ -0,0 +1,531 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// CacheOpts represents a set of response cache options.
+type CacheOpts struct {
+	// MaxSize is the maximum size in bytes of all cached responses. The least
+	// recently used responses are evicted to make room for new ones.
+	// Defaults to 32 MiB.
+	MaxSize int
+
+	// DefaultTTL is how long responses without explicit freshness, from a
+	// Cache-Control max-age or an Expires header, are cached. Zero only caches
+	// responses with explicit freshness.
+	DefaultTTL time.Duration
+}
+
+// Cache is a middleware that caches responses in memory, up to maxSize bytes.
+// See CacheWithOpts.
+func Cache(maxSize int) func(next http.Handler) http.Handler {
+	return CacheWithOpts(CacheOpts{MaxSize: maxSize})
+}
+
+// CacheWithOpts is a middleware that caches the responses of GET and HEAD
+// requests in memory, as a shared HTTP cache would, using passed CacheOpts.
+//
+// Responses are cached by method, path, query and the request headers listed
+// in their Vary header, for as long as their Cache-Control s-maxage or
+// max-age, or Expires header allow. Responses with Cache-Control no-store,
+// no-cache or private, or with a Set-Cookie header, are not cached. Requests
+// with Cache-Control no-cache bypass the cache and refresh it, and requests
+// with no-store bypass it entirely.
+//
+// Stale responses are served for up to the Cache-Control stale-while-revalidate
+// duration while the cache is refreshed in the background. Concurrent misses
+// for the same resource are collapsed into a single request to the handler.
+//
+// Cached responses are served with the Age and X-Cache headers.
+func CacheWithOpts(opts CacheOpts) func(next http.Handler) http.Handler {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 32 << 20
+	}
+	return newResponseCache(opts).handler
+}
+
+func newResponseCache(opts CacheOpts) *responseCache {
+	return &responseCache{
+		opts:     opts,
+		ll:       list.New(),
+		entries:  map[string]*list.Element{},
+		varies:   map[string]*cacheVary{},
+		inflight: map[string]*cacheCall{},
+		now:      time.Now,
+	}
+}
+
+func (c *responseCache) handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+		if _, ok := reqCC["no-store"]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, noCache := reqCC["no-cache"]
+		if r.Header.Get("Pragma") == "no-cache" {
+			noCache = true
+		}
+
+		base := r.Method + " " + r.URL.RequestURI()
+
+		if !noCache {
+			if e, stale := c.lookup(r, base); e != nil {
+				if stale {
+					c.revalidate(next, r, base, e)
+				}
+				c.serve(w, r, e, stale)
+				return
+			}
+		}
+
+		// Collapse concurrent misses. The followers wait for the response
+		// of the leader, and only call the handler themselves when the
+		// response couldn't be cached for them.
+		call, leader := c.join(base)
+		if !leader {
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return
+			}
+			if e, stale := c.lookup(r, base); e != nil && !stale {
+				c.serve(w, r, e, false)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer c.leave(base, call)
+
+		ww := NewWrapResponseWriter(w, r.ProtoMajor)
+		buf := &cacheBuffer{limit: c.opts.MaxSize}
+		ww.Tee(buf)
+		ww.Header().Set("X-Cache", "MISS")
+
+		next.ServeHTTP(ww, r)
+
+		if !buf.overflow {
+			c.store(r, base, ww.Status(), ww.Header(), buf.buf)
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+type responseCache struct {
+	opts CacheOpts
+	now  func() time.Time
+
+	mu       sync.Mutex
+	ll       *list.List
+	entries  map[string]*list.Element
+	varies   map[string]*cacheVary
+	size     int
+	inflight map[string]*cacheCall
+}
+
+// cacheVary records the request headers the responses of a base key vary on,
+// and the keys of its cached variants.
+type cacheVary struct {
+	headers []string
+	keys    map[string]struct{}
+}
+
+type cacheEntry struct {
+	key        string
+	base       string
+	status     int
+	header     http.Header
+	body       []byte
+	stored     time.Time
+	expires    time.Time
+	staleUntil time.Time
+	size       int
+
+	revalidating bool
+}
+
+type cacheCall struct {
+	done chan struct{}
+}
+
+func (c *responseCache) join(base string) (*cacheCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.inflight[base]; ok {
+		return call, false
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[base] = call
+	return call, true
+}
+
+func (c *responseCache) leave(base string, call *cacheCall) {
+	c.mu.Lock()
+	delete(c.inflight, base)
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// varyKey builds the cache key of a request from the base key and the
+// values of the request headers the response varies on.
+func varyKey(r *http.Request, base string, vary []string) string {
+	if len(vary) == 0 {
+		return base
+	}
+	var b strings.Builder
+	b.WriteString(base)
+	for _, h := range vary {
+		b.WriteString("\n")
+		b.WriteString(h)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// lookup returns the cached response for the request, and whether it's stale
+// but still usable while it's revalidated.
+func (c *responseCache) lookup(r *http.Request, base string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vary, ok := c.varies[base]
+	if !ok {
+		return nil, false
+	}
+	el, ok := c.entries[varyKey(r, base, vary.headers)]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+
+	now := c.now()
+	if now.Before(e.expires) {
+		c.ll.MoveToFront(el)
+		return e, false
+	}
+	if now.Before(e.staleUntil) {
+		c.ll.MoveToFront(el)
+		return e, true
+	}
+	c.remove(el)
+	return nil, false
+}
+
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, e *cacheEntry, stale bool) {
+	header := w.Header()
+	for k, v := range e.header {
+		header[k] = append([]string(nil), v...)
+	}
+	age := int(c.now().Sub(e.stored).Seconds())
+	header.Set("Age", strconv.Itoa(age))
+	if stale {
+		header.Set("X-Cache", "STALE")
+	} else {
+		header.Set("X-Cache", "HIT")
+	}
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// store caches a response, if it's cacheable by a shared cache.
+func (c *responseCache) store(r *http.Request, base string, status int, header http.Header, body []byte) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if !isCacheableStatus(status) || header.Get("Set-Cookie") != "" {
+		return
+	}
+
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return
+		}
+	}
+	if r.Header.Get("Authorization") != "" {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		_, mustRevalidate := cc["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return
+		}
+	}
+
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+			if h == "*" {
+				return
+			}
+			if h != "" {
+				vary = append(vary, h)
+			}
+		}
+	}
+
+	now := c.now()
+	ttl, ok := freshnessLifetime(cc, header, now)
+	if !ok {
+		ttl = c.opts.DefaultTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	e := &cacheEntry{
+		key:     varyKey(r, base, vary),
+		base:    base,
+		status:  status,
+		header:  header.Clone(),
+		body:    append([]byte(nil), body...),
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+	e.header.Del("X-Cache")
+	e.header.Del("Age")
+	e.staleUntil = e.expires
+	if swr, ok := cc["stale-while-revalidate"]; ok {
+		if secs, err := strconv.Atoi(swr); err == nil && secs > 0 {
+			e.staleUntil = e.expires.Add(time.Duration(secs) * time.Second)
+		}
+	}
+
+	e.size = len(e.body) + len(e.key)
+	for k, v := range e.header {
+		e.size += len(k)
+		for _, vv := range v {
+			e.size += len(vv)
+		}
+	}
+	if e.size > c.opts.MaxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	v, ok := c.varies[base]
+	if ok && !equalStrings(v.headers, vary) {
+		// The variants stored under the previous Vary headers can't be
+		// looked up anymore.
+		for key := range v.keys {
+			c.remove(c.entries[key])
+		}
+		ok = false
+	}
+	if !ok {
+		v = &cacheVary{headers: vary, keys: map[string]struct{}{}}
+		c.varies[base] = v
+	}
+	v.keys[e.key] = struct{}{}
+	c.entries[e.key] = c.ll.PushFront(e)
+	c.size += e.size
+	for c.size > c.opts.MaxSize {
+		c.remove(c.ll.Back())
+	}
+}
+
+// remove evicts an entry, the cache lock must be held.
+func (c *responseCache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if v, ok := c.varies[e.base]; ok {
+		delete(v.keys, e.key)
+		if len(v.keys) == 0 {
+			delete(c.varies, e.base)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// revalidate refreshes a stale entry in the background, unless it's already
+// being refreshed.
+func (c *responseCache) revalidate(next http.Handler, r *http.Request, base string, e *cacheEntry) {
+	c.mu.Lock()
+	if e.revalidating {
+		c.mu.Unlock()
+		return
+	}
+	e.revalidating = true
+	c.mu.Unlock()
+
+	// The request outlives the original one, so it gets its own routing
+	// context and a context that is never canceled.
+	ctx := context.Context(detachedContext{r.Context()})
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, copyRouteContext(rctx))
+	}
+	req := r.Clone(ctx)
+	req.Header.Del("Cache-Control")
+	req.Header.Del("Pragma")
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			e.revalidating = false
+			c.mu.Unlock()
+		}()
+
+		pw := &probeResponseWriter{header: http.Header{}}
+		ww := NewWrapResponseWriter(pw, req.ProtoMajor)
+		buf := &cacheBuffer{limit: c.opts.MaxSize}
+		ww.Tee(buf)
+		next.ServeHTTP(ww, req)
+		if !buf.overflow {
+			c.store(req, base, ww.Status(), pw.header, buf.buf)
+		}
+	}()
+}
+
+// copyRouteContext copies the state of a routing context at the current
+// point of routing.
+func copyRouteContext(rctx *chi.Context) *chi.Context {
+	x := chi.NewRouteContext()
+	x.Routes = rctx.Routes
+	x.RoutePath = rctx.RoutePath
+	x.RouteMethod = rctx.RouteMethod
+	x.URLParams.Keys = append([]string(nil), rctx.URLParams.Keys...)
+	x.URLParams.Values = append([]string(nil), rctx.URLParams.Values...)
+	x.RoutePatterns = append([]string(nil), rctx.RoutePatterns...)
+	return x
+}
+
+// detachedContext keeps the values of its parent context, but is never
+// canceled nor has a deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// cacheBuffer captures a response body up to a limit.
+type cacheBuffer struct {
+	buf      []byte
+	limit    int
+	overflow bool
+}
+
+func (b *cacheBuffer) Write(p []byte) (int, error) {
+	if b.overflow {
+		return len(p), nil
+	}
+	if len(b.buf)+len(p) > b.limit {
+		b.overflow = true
+		b.buf = nil
+		return len(p), nil
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// isCacheableStatus reports whether responses with the status code are
+// cacheable by default, see RFC 7231, section 6.1.
+func isCacheableStatus(status int) bool {
+	switch status {
+	case 200, 203, 204, 300, 301, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
+
+// freshnessLifetime returns how long a response is fresh, from the
+// Cache-Control s-maxage and max-age directives or the Expires header.
+func freshnessLifetime(cc map[string]string, header http.Header, now time.Time) (time.Duration, bool) {
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[d]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil {
+				return 0, true
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0, true
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		return expires.Sub(date), true
+	}
+	return 0, false
+}
+
+// parseCacheControl parses the directives of a Cache-Control header.
+func parseCacheControl(v string) map[string]string {
+	cc := map[string]string{}
+	for _, d := range strings.Split(v, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		name, value := d, ""
+		if i := strings.IndexByte(d, '='); i >= 0 {
+			name, value = d[:i], strings.Trim(strings.TrimSpace(d[i+1:]), `"`)
+		}
+		cc[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return cc
+}
+
+// probeResponseWriter records the status and headers of a revalidation
+// response, discarding its body.
+type probeResponseWriter struct {
+	header http.Header
+	code   int
+}
+
+func (pw *probeResponseWriter) Header() http.Header { return pw.header }
+
+func (pw *probeResponseWriter) Write(p []byte) (int, error) {
+	if pw.code == 0 {
+		pw.code = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (pw *probeResponseWriter) WriteHeader(code int) {
+	if pw.code == 0 {
+		pw.code = code
+	}
+}
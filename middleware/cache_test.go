@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newResponseCache(CacheOpts{MaxSize: 1 << 20})
+	c.now = func() time.Time { return now }
+
+	var hits int32
+	r := chi.NewRouter()
+	r.Use(c.handler)
+	r.Get("/fresh", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "fresh %d %s", n, r.URL.Query().Get("q"))
+	})
+	r.Get("/private", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		fmt.Fprintf(w, "private %d", n)
+	})
+	r.Get("/lang", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+	r.Get("/expires", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.Header().Set("Date", now.Format(http.TimeFormat))
+		w.Header().Set("Expires", now.Add(10*time.Second).Format(http.TimeFormat))
+		fmt.Fprintf(w, "expires %d", n)
+	})
+
+	do := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("/fresh?q=a", nil)
+	assertEqual(t, "fresh 1 a", w.Body.String())
+	assertEqual(t, "MISS", w.Header().Get("X-Cache"))
+
+	now = now.Add(30 * time.Second)
+	w = do("/fresh?q=a", nil)
+	assertEqual(t, "fresh 1 a", w.Body.String())
+	assertEqual(t, "HIT", w.Header().Get("X-Cache"))
+	assertEqual(t, "30", w.Header().Get("Age"))
+
+	// The query is part of the key.
+	assertEqual(t, "fresh 2 b", do("/fresh?q=b", nil).Body.String())
+
+	// Request no-cache refreshes the cache, no-store bypasses it.
+	assertEqual(t, "fresh 3 a", do("/fresh?q=a", http.Header{"Cache-Control": {"no-cache"}}).Body.String())
+	assertEqual(t, "fresh 3 a", do("/fresh?q=a", nil).Body.String())
+	assertEqual(t, "fresh 4 a", do("/fresh?q=a", http.Header{"Cache-Control": {"no-store"}}).Body.String())
+
+	// Expired responses are fetched again.
+	now = now.Add(61 * time.Second)
+	assertEqual(t, "fresh 5 a", do("/fresh?q=a", nil).Body.String())
+
+	// Private responses aren't cached.
+	assertEqual(t, "private 6", do("/private", nil).Body.String())
+	assertEqual(t, "private 7", do("/private", nil).Body.String())
+
+	// Responses vary on the request headers listed in Vary.
+	assertEqual(t, "en", do("/lang", http.Header{"Accept-Language": {"en"}}).Body.String())
+	assertEqual(t, "fr", do("/lang", http.Header{"Accept-Language": {"fr"}}).Body.String())
+	w = do("/lang", http.Header{"Accept-Language": {"en"}})
+	assertEqual(t, "en", w.Body.String())
+	assertEqual(t, "HIT", w.Header().Get("X-Cache"))
+
+	// Expires is relative to the Date of the response.
+	assertEqual(t, "expires 8", do("/expires", nil).Body.String())
+	now = now.Add(5 * time.Second)
+	assertEqual(t, "expires 8", do("/expires", nil).Body.String())
+	now = now.Add(10 * time.Second)
+	assertEqual(t, "expires 9", do("/expires", nil).Body.String())
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := newResponseCache(CacheOpts{MaxSize: 1200})
+
+	var hits int32
+	r := chi.NewRouter()
+	r.Use(c.handler)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(make([]byte, 300))
+	})
+
+	for _, path := range []string{"/1", "/2", "/3", "/1", "/4", "/1", "/2"} {
+		req := httptest.NewRequest("GET", path, nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// "/1" stays cached as it's the most recently used, while "/2" is evicted
+	// to make room for "/4".
+	assertEqual(t, int32(5), atomic.LoadInt32(&hits))
+	if c.size > 1200 {
+		t.Fatalf("cache size %d over its max size", c.size)
+	}
+}
+
+func TestCacheEvictionForgetsVaries(t *testing.T) {
+	c := newResponseCache(CacheOpts{MaxSize: 1200})
+
+	r := chi.NewRouter()
+	r.Use(c.handler)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write(make([]byte, 300))
+	})
+
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest("GET", "/"+strconv.Itoa(i), nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(c.varies) != len(c.entries) || len(c.varies) > 3 {
+		t.Fatalf("expecting the varies of the %d cached entries only, got %d", len(c.entries), len(c.varies))
+	}
+}
+
+func TestCacheVaryChange(t *testing.T) {
+	c := newResponseCache(CacheOpts{MaxSize: 1 << 20})
+	vary := "Accept-Language"
+
+	r := chi.NewRouter()
+	r.Use(c.handler)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", vary)
+		w.Write([]byte("ok"))
+	})
+
+	get := func(lang string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", lang)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	get("en")
+	get("fr")
+	assertEqual(t, 2, len(c.entries))
+
+	// The variants of the previous Vary headers are dropped.
+	vary = "Accept-Encoding"
+	get("de")
+	assertEqual(t, 1, len(c.entries))
+	assertEqual(t, 1, len(c.varies["GET /"].keys))
+	assertEqual(t, 1, c.ll.Len())
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	c := newResponseCache(CacheOpts{MaxSize: 1 << 20})
+	c.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+
+	var hits int32
+	revalidated := make(chan struct{}, 1)
+	r := chi.NewRouter()
+	r.Use(c.handler)
+	r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=30")
+		fmt.Fprintf(w, "%s %d", chi.URLParam(r, "id"), n)
+		if n > 1 {
+			revalidated <- struct{}{}
+		}
+	})
+
+	do := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/items/1", nil))
+		return w
+	}
+
+	assertEqual(t, "1 1", do().Body.String())
+
+	advance(20 * time.Second)
+	w := do()
+	assertEqual(t, "1 1", w.Body.String())
+	assertEqual(t, "STALE", w.Header().Get("X-Cache"))
+
+	select {
+	case <-revalidated:
+	case <-time.After(time.Second):
+		t.Fatal("expecting the stale response to be revalidated")
+	}
+	for i := 0; i < 100; i++ {
+		if w = do(); w.Header().Get("X-Cache") == "HIT" {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assertEqual(t, "1 2", w.Body.String())
+}
+
+func TestCacheCollapse(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+
+	r := chi.NewRouter()
+	r.Use(Cache(1 << 20))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("slow"))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Body.String() != "slow" {
+				t.Errorf("unexpected body '%s'", w.Body.String())
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assertEqual(t, int32(1), atomic.LoadInt32(&hits))
+
+	// Followers give up waiting for the leader once their client is gone.
+	atomic.StoreInt32(&hits, 0)
+	release = make(chan struct{})
+	leader := make(chan struct{})
+	go func() {
+		defer close(leader)
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?follow", nil))
+	}()
+	for atomic.LoadInt32(&hits) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	follower := make(chan struct{})
+	go func() {
+		defer close(follower)
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?follow", nil).WithContext(ctx))
+	}()
+	cancel()
+	select {
+	case <-follower:
+	case <-time.After(time.Second):
+		t.Fatal("expecting the canceled follower to return")
+	}
+	close(release)
+	<-leader
+}
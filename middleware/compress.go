@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -32,10 +33,10 @@ var defaultCompressibleContentTypes = []string{
 // on Accept-Encoding request header. It uses a given
 // compression level.
 //
-// NOTE: make sure to set the Content-Type header on your response
-// otherwise this middleware will not compress the response body. For ex, in
-// your handler you should set w.Header().Set("Content-Type", http.DetectContentType(yourBody))
-// or set it manually.
+// When the Content-Type header of the response is missing, it's detected from
+// the start of the body with http.DetectContentType. Responses without a body,
+// partial content responses and responses with a Cache-Control no-transform
+// directive are never compressed.
 //
 // Passing a compression level of 5 is sensible value
 func Compress(level int, types ...string) func(next http.Handler) http.Handler {
@@ -55,6 +56,7 @@ type Compressor struct {
 	// The list of encoders in order of decreasing precedence.
 	encodingPrecedence []string
 	level              int // The compression level.
+	minLength          int // The minimum length of compressed bodies.
 }
 
 // NewCompressor creates a new Compressor that will handle encoding responses.
@@ -189,11 +191,36 @@ func (c *Compressor) SetEncoder(encoding string, fn EncoderFunc) {
 	c.encodingPrecedence = append([]string{encoding}, c.encodingPrecedence...)
 }
 
+// SetMinLength sets the minimum length of the response bodies to compress.
+// Smaller bodies don't benefit from compression, and are sent as they are.
+//
+// Up to length bytes of the body are buffered before deciding whether to
+// compress it, or until the response is flushed.
+func (c *Compressor) SetMinLength(length int) {
+	if length < 0 {
+		panic("chi/middleware: Compressor expects a minimum length >= 0")
+	}
+	c.minLength = length
+}
+
 // Handler returns a new middleware that will compress the response based on the
 // current Compressor.
+//
+// The encoding is negotiated from the q-values of the Accept-Encoding request
+// header. Requests refusing all the available encodings, identity included,
+// are responded with a 406 Not Acceptable.
 func (c *Compressor) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		encoder, encoding, cleanup := c.selectEncoder(r.Header, w)
+		accepted := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+		encoder, encoding, cleanup := c.selectEncoder(accepted, w)
+
+		// The client refuses the identity encoding, and none of the
+		// encodings it accepts is available.
+		if encoding == "" && accepted.quality("identity") == 0 {
+			w.Header().Add("Vary", "Accept-Encoding")
+			http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+			return
+		}
 
 		cw := &compressResponseWriter{
 			ResponseWriter:   w,
@@ -201,6 +228,7 @@ func (c *Compressor) Handler(next http.Handler) http.Handler {
 			contentTypes:     c.allowedTypes,
 			contentWildcards: c.allowedWildcards,
 			encoding:         encoding,
+			minLength:        c.minLength,
 			compressable:     false, // determined in post-handler
 		}
 		if encoder != nil {
@@ -215,42 +243,85 @@ func (c *Compressor) Handler(next http.Handler) http.Handler {
 }
 
 // selectEncoder returns the encoder, the name of the encoder, and a closer function.
-func (c *Compressor) selectEncoder(h http.Header, w io.Writer) (io.Writer, string, func()) {
-	header := h.Get("Accept-Encoding")
-
-	// Parse the names of all accepted algorithms from the header.
-	accepted := strings.Split(strings.ToLower(header), ",")
-
-	// Find supported encoder by accepted list by precedence
-	for _, name := range c.encodingPrecedence {
-		if matchAcceptEncoding(accepted, name) {
-			if pool, ok := c.pooledEncoders[name]; ok {
-				encoder := pool.Get().(ioResetterWriter)
-				cleanup := func() {
-					pool.Put(encoder)
-				}
-				encoder.Reset(w)
-				return encoder, name, cleanup
-
-			}
-			if fn, ok := c.encoders[name]; ok {
-				return fn(w, c.level), name, func() {}
-			}
+//
+// The encoding with the highest q-value accepted by the client is selected,
+// using the encoding precedence of the Compressor to break ties.
+func (c *Compressor) selectEncoder(accepted acceptEncoding, w io.Writer) (io.Writer, string, func()) {
+	name, best := "", 0.0
+	for _, v := range c.encodingPrecedence {
+		if q := accepted.quality(v); q > best {
+			name, best = v, q
 		}
-
+	}
+	if name == "" {
+		// No encoder found to match the accepted encoding
+		return nil, "", func() {}
 	}
 
-	// No encoder found to match the accepted encoding
+	if pool, ok := c.pooledEncoders[name]; ok {
+		encoder := pool.Get().(ioResetterWriter)
+		cleanup := func() {
+			pool.Put(encoder)
+		}
+		encoder.Reset(w)
+		return encoder, name, cleanup
+	}
+	if fn, ok := c.encoders[name]; ok {
+		if encoder := fn(w, c.level); encoder != nil {
+			return encoder, name, func() {}
+		}
+	}
 	return nil, "", func() {}
 }
 
-func matchAcceptEncoding(accepted []string, encoding string) bool {
-	for _, v := range accepted {
-		if strings.Contains(v, encoding) {
-			return true
+// acceptEncoding maps the content codings of an Accept-Encoding header to
+// their q-values.
+type acceptEncoding map[string]float64
+
+// parseAcceptEncoding parses an Accept-Encoding header, see RFC 7231,
+// section 5.3.4. Codings with an invalid q-value are ignored.
+func parseAcceptEncoding(header string) acceptEncoding {
+	accepted := acceptEncoding{}
+	for _, v := range strings.Split(strings.ToLower(header), ",") {
+		params := strings.Split(v, ";")
+		coding := strings.TrimSpace(params[0])
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") {
+				continue
+			}
+			f, err := strconv.ParseFloat(p[2:], 64)
+			if err != nil || f < 0 || f > 1 {
+				q = -1
+			} else {
+				q = f
+			}
+		}
+		if q >= 0 {
+			accepted[coding] = q
 		}
 	}
-	return false
+	return accepted
+}
+
+// quality returns the q-value of a content coding. Codings that aren't
+// listed get the q-value of "*", if any, except identity, which is
+// acceptable unless explicitly refused.
+func (a acceptEncoding) quality(coding string) float64 {
+	if q, ok := a[coding]; ok {
+		return q
+	}
+	if q, ok := a["*"]; ok {
+		return q
+	}
+	if coding == "identity" {
+		return 1
+	}
+	return 0
 }
 
 // An EncoderFunc is a function that wraps the provided io.Writer with a
@@ -274,8 +345,15 @@ type compressResponseWriter struct {
 	contentTypes     map[string]struct{}
 	contentWildcards map[string]struct{}
 	encoding         string
+	minLength        int
 	wroteHeader      bool
 	compressable     bool
+
+	// The status code and the start of the body are held back until enough
+	// of the body is known to decide whether to compress it.
+	code    int
+	buf     []byte
+	decided bool
 }
 
 func (cw *compressResponseWriter) isCompressable() bool {
@@ -298,39 +376,120 @@ func (cw *compressResponseWriter) isCompressable() bool {
 }
 
 func (cw *compressResponseWriter) WriteHeader(code int) {
-	if cw.wroteHeader {
+	if cw.decided {
 		cw.ResponseWriter.WriteHeader(code) // Allow multiple calls to propagate.
 		return
 	}
+	// Informational responses are sent as they come.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.wroteHeader {
+		return
+	}
 	cw.wroteHeader = true
-	defer cw.ResponseWriter.WriteHeader(code)
+	cw.code = code
 
-	// Already compressed data?
-	if cw.Header().Get("Content-Encoding") != "" {
-		return
+	switch code {
+	case http.StatusSwitchingProtocols, http.StatusNoContent, http.StatusNotModified:
+		// There is no body to compress.
+		cw.decide(true)
 	}
+}
 
-	if !cw.isCompressable() {
-		cw.compressable = false
-		return
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		return cw.writer().Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) > 0 && len(cw.buf) >= cw.minLength {
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide decides whether to compress the response from its status, headers
+// and the body buffered so far, then sends the headers and the buffered body.
+// The final flag tells that the buffered body is the whole body.
+func (cw *compressResponseWriter) decide(final bool) error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.code = http.StatusOK
 	}
 
-	if cw.encoding != "" {
-		cw.compressable = true
+	cw.compressable = cw.shouldCompress(final)
+	if cw.compressable {
 		cw.Header().Set("Content-Encoding", cw.encoding)
-		cw.Header().Set("Vary", "Accept-Encoding")
+		addVary(cw.Header(), "Accept-Encoding")
+
+		// The compressed bytes differ from those a strong ETag was computed
+		// from, so the ETag only remains valid as a weak one.
+		if etag := cw.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			cw.Header().Set("ETag", "W/"+etag)
+		}
 
 		// The content-length after compression is unknown
 		cw.Header().Del("Content-Length")
 	}
+	cw.ResponseWriter.WriteHeader(cw.code)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.writer().Write(buf)
+	return err
 }
 
-func (cw *compressResponseWriter) Write(p []byte) (int, error) {
-	if !cw.wroteHeader {
-		cw.WriteHeader(http.StatusOK)
+func (cw *compressResponseWriter) shouldCompress(final bool) bool {
+	if cw.encoding == "" {
+		return false
+	}
+
+	// Responses without a body, and partial content, which compressing would
+	// corrupt, are sent as they are.
+	switch cw.code {
+	case http.StatusSwitchingProtocols, http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	if cw.Header().Get("Content-Range") != "" {
+		return false
 	}
 
-	return cw.writer().Write(p)
+	// Already compressed data?
+	if cw.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	for _, v := range cw.Header().Values("Cache-Control") {
+		if strings.Contains(strings.ToLower(v), "no-transform") {
+			return false
+		}
+	}
+
+	// A flushed response is streamed, and presumably long enough.
+	if final && (len(cw.buf) == 0 || len(cw.buf) < cw.minLength) {
+		return false
+	}
+
+	// Sniff the content type, as net/http would, when it's missing.
+	if _, ok := cw.Header()["Content-Type"]; !ok && len(cw.buf) > 0 {
+		cw.Header().Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	return cw.isCompressable()
 }
 
 func (cw *compressResponseWriter) writer() io.Writer {
@@ -346,6 +505,8 @@ type compressFlusher interface {
 }
 
 func (cw *compressResponseWriter) Flush() {
+	cw.decide(false)
+
 	if f, ok := cw.writer().(http.Flusher); ok {
 		f.Flush()
 	}
@@ -376,6 +537,13 @@ func (cw *compressResponseWriter) Push(target string, opts *http.PushOptions) er
 }
 
 func (cw *compressResponseWriter) Close() error {
+	// Send what is still buffered, unless the response was never started,
+	// as when the connection was hijacked.
+	if cw.wroteHeader || len(cw.buf) > 0 {
+		if err := cw.decide(true); err != nil {
+			return err
+		}
+	}
 	if c, ok := cw.writer().(io.WriteCloser); ok {
 		return c.Close()
 	}
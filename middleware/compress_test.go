@@ -93,6 +93,30 @@ func TestCompressor(t *testing.T) {
 			acceptedEncodings: []string{"nop, gzip, deflate"},
 			expectedEncoding:  "nop",
 		},
+		{
+			name:              "refused encoding is not used",
+			path:              "/gethtml",
+			acceptedEncodings: []string{"gzip;q=0", "deflate"},
+			expectedEncoding:  "deflate",
+		},
+		{
+			name:              "highest q-value is preferred",
+			path:              "/gethtml",
+			acceptedEncodings: []string{"nop;q=0.2", "gzip;q=0.5", "deflate;q=0.8"},
+			expectedEncoding:  "deflate",
+		},
+		{
+			name:              "wildcard uses precedence",
+			path:              "/gethtml",
+			acceptedEncodings: []string{"*;q=0.5", "nop;q=0", "deflate;q=0.5"},
+			expectedEncoding:  "gzip",
+		},
+		{
+			name:              "unsupported encoding falls back to identity",
+			path:              "/gethtml",
+			acceptedEncodings: []string{"br"},
+			expectedEncoding:  "",
+		},
 	}
 
 	for _, tc := range tests {
@@ -171,6 +195,118 @@ func TestCompressorWildcards(t *testing.T) {
 	}
 }
 
+func TestCompressorNotAcceptable(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Compress(5))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("textstring"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		encodings string
+		status    int
+	}{
+		{"br, identity;q=0", 406},
+		{"br, *;q=0", 406},
+		{"gzip, identity;q=0", 200},
+		{"br, *;q=0, identity", 200},
+	} {
+		resp, _ := testRequestWithAcceptedEncodings(t, ts, "GET", "/", tc.encodings)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d but got %d", tc.encodings, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestCompressorMinLength(t *testing.T) {
+	compressor := NewCompressor(5)
+	compressor.SetMinLength(100)
+
+	r := chi.NewRouter()
+	r.Use(compressor.Handler)
+	r.Get("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	})
+	r.Get("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for i := 0; i < 20; i++ {
+			w.Write([]byte(`{"ok":true}`))
+		}
+	})
+	r.Get("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("chunk"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("chunk"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, body := testRequestWithAcceptedEncodings(t, ts, "GET", "/small", "gzip")
+	assertEqual(t, "", resp.Header.Get("Content-Encoding"))
+	assertEqual(t, `{"ok":true}`, body)
+
+	resp, body = testRequestWithAcceptedEncodings(t, ts, "GET", "/large", "gzip")
+	assertEqual(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assertEqual(t, strings.Repeat(`{"ok":true}`, 20), body)
+
+	resp, body = testRequestWithAcceptedEncodings(t, ts, "GET", "/stream", "gzip")
+	assertEqual(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assertEqual(t, "chunkchunk", body)
+}
+
+func TestCompressorSkip(t *testing.T) {
+	page := "<!DOCTYPE html><html><body>" + strings.Repeat("compress me ", 20) + "</body></html>"
+
+	r := chi.NewRouter()
+	r.Use(Compress(5))
+	r.Get("/sniff", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	})
+	r.Get("/range", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-9/%d", len(page)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(page[:10]))
+	})
+	r.Get("/no-transform", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "public, no-transform")
+		w.Write([]byte(page))
+	})
+	r.Get("/not-modified", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotModified)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, body := testRequestWithAcceptedEncodings(t, ts, "GET", "/sniff", "gzip")
+	assertEqual(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assertEqual(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assertEqual(t, page, body)
+
+	resp, body = testRequestWithAcceptedEncodings(t, ts, "GET", "/range", "gzip")
+	assertEqual(t, 206, resp.StatusCode)
+	assertEqual(t, "", resp.Header.Get("Content-Encoding"))
+	assertEqual(t, page[:10], body)
+
+	resp, body = testRequestWithAcceptedEncodings(t, ts, "GET", "/no-transform", "gzip")
+	assertEqual(t, "", resp.Header.Get("Content-Encoding"))
+	assertEqual(t, page, body)
+
+	resp, _ = testRequestWithAcceptedEncodings(t, ts, "GET", "/not-modified", "gzip")
+	assertEqual(t, 304, resp.StatusCode)
+	assertEqual(t, "", resp.Header.Get("Content-Encoding"))
+}
+
 func testRequestWithAcceptedEncodings(t *testing.T, ts *httptest.Server, method, path string, encodings ...string) (*http.Response, string) {
 	req, err := http.NewRequest(method, ts.URL+path, nil)
 	if err != nil {
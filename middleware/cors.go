@@ -0,0 +1,290 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// corsMethods is the list of methods checked against the routing tree to
+// answer a preflight request.
+var corsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodConnect, http.MethodOptions,
+	http.MethodTrace,
+}
+
+// CORSOptions represents a set of Cross-Origin Resource Sharing options.
+type CORSOptions struct {
+	// AllowedOrigins is the list of origins a cross-domain request can be
+	// executed from. An origin may contain a single "*" wildcard to match
+	// subdomains, for example "https://*.example.com", and "*" allows any
+	// origin.
+	AllowedOrigins []string
+
+	// AllowOriginFunc is a predicate to validate the origin, checked when
+	// the origin doesn't match any of the AllowedOrigins.
+	AllowOriginFunc func(r *http.Request, origin string) bool
+
+	// AllowedMethods is the list of methods answered to preflight requests
+	// when the routing tree is not available, such as outside of a chi router.
+	// Otherwise, when set, only the routed methods listed here are answered,
+	// including custom ones. Defaults to GET, HEAD and POST.
+	AllowedMethods []string
+
+	// AllowedHeaders is the list of non-simple headers the client may use
+	// in cross-domain requests, "*" allows any header. Defaults to Accept,
+	// Authorization and Content-Type.
+	AllowedHeaders []string
+
+	// ExposedHeaders is the list of response headers made available to the
+	// client.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies, HTTP authentication or
+	// client side SSL certificates.
+	AllowCredentials bool
+
+	// MaxAge is how long, in seconds, the result of a preflight request can
+	// be cached by the client. Zero omits the header.
+	MaxAge int
+}
+
+// CORS is a middleware that implements Cross-Origin Resource Sharing.
+//
+// Preflight requests are answered with the methods actually registered for
+// the requested path in the whole routing tree, so CORS can be set on the
+// router or on a sub-router with r.Use(), before any routes are defined. For
+// example:
+//
+//  r := chi.NewRouter()
+//  r.Use(middleware.CORS(middleware.CORSOptions{
+//    AllowedOrigins:   []string{"https://example.com", "https://*.example.com"},
+//    AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+//    ExposedHeaders:   []string{"Link"},
+//    AllowCredentials: true,
+//    MaxAge:           300,
+//  }))
+func CORS(opts CORSOptions) func(next http.Handler) http.Handler {
+	c := &cors{
+		allowOriginFunc:  opts.AllowOriginFunc,
+		allowCredentials: opts.AllowCredentials,
+		exposedHeaders:   strings.Join(opts.ExposedHeaders, ", "),
+	}
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			c.allowAllOrigins = true
+		}
+		c.allowedOrigins = append(c.allowedOrigins, NewPattern(strings.ToLower(o)))
+	}
+
+	c.allowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	if len(opts.AllowedMethods) > 0 {
+		c.restrictMethods = true
+		c.allowedMethods = make([]string, len(opts.AllowedMethods))
+		for i, m := range opts.AllowedMethods {
+			c.allowedMethods[i] = strings.ToUpper(m)
+		}
+	}
+	c.routeMethods = append([]string{}, corsMethods...)
+	for _, m := range c.allowedMethods {
+		if !containsString(c.routeMethods, m) {
+			c.routeMethods = append(c.routeMethods, m)
+		}
+	}
+
+	allowedHeaders := opts.AllowedHeaders
+	if len(allowedHeaders) == 0 {
+		allowedHeaders = []string{"Accept", "Authorization", "Content-Type"}
+	}
+	for _, h := range allowedHeaders {
+		if h == "*" {
+			c.allowAllHeaders = true
+		}
+		c.allowedHeaders = append(c.allowedHeaders, http.CanonicalHeaderKey(strings.TrimSpace(h)))
+	}
+
+	if opts.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(opts.MaxAge)
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			// A preflight request always has an Origin, other OPTIONS requests
+			// are the router's.
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" && r.Header.Get("Origin") != "" {
+				c.handlePreflight(w, r, next)
+				return
+			}
+			c.handleActual(w, r)
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+type cors struct {
+	allowedOrigins   []Pattern
+	allowAllOrigins  bool
+	allowOriginFunc  func(r *http.Request, origin string) bool
+	allowedMethods   []string
+	restrictMethods  bool
+	routeMethods     []string
+	allowedHeaders   []string
+	allowAllHeaders  bool
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+func (c *cors) handlePreflight(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	header := w.Header()
+	addVary(header, "Origin")
+	addVary(header, "Access-Control-Request-Method")
+	addVary(header, "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	if !c.isOriginAllowed(r, origin) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	methods, routed := c.routeMethodsFor(r)
+	if routed && methods == nil {
+		// Nothing is routed along the path, let the router respond.
+		next.ServeHTTP(w, r)
+		return
+	}
+	reqMethod := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !containsString(methods, reqMethod) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var reqHeaders []string
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if !c.allowAllHeaders && !containsString(c.allowedHeaders, h) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		reqHeaders = append(reqHeaders, h)
+	}
+
+	c.setAllowOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(reqHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+	}
+	if c.maxAge != "" {
+		header.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *cors) handleActual(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	addVary(header, "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !c.isOriginAllowed(r, origin) {
+		return
+	}
+	c.setAllowOrigin(header, origin)
+	if c.exposedHeaders != "" {
+		header.Set("Access-Control-Expose-Headers", c.exposedHeaders)
+	}
+}
+
+func (c *cors) setAllowOrigin(header http.Header, origin string) {
+	// The wildcard can't be used along with credentials, the origin
+	// is echoed back instead.
+	if c.allowAllOrigins && !c.allowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *cors) isOriginAllowed(r *http.Request, origin string) bool {
+	o := strings.ToLower(origin)
+	for _, p := range c.allowedOrigins {
+		if p.Match(o) {
+			return true
+		}
+	}
+	if c.allowOriginFunc != nil {
+		return c.allowOriginFunc(r, origin)
+	}
+	return false
+}
+
+// routeMethodsFor returns the methods routed along the request path by the
+// top-level chi router, or the AllowedMethods when no router is available. It returns
+// nil when nothing is routed along the path. When the AllowedMethods are set,
+// the routed methods are limited to them.
+func (c *cors) routeMethodsFor(r *http.Request) ([]string, bool) {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return c.allowedMethods, false
+	}
+
+	// The routing context holds the top-level router, also in sub-routers
+	// where the RoutePath is relative to the sub-router, so the full path is
+	// matched.
+	routePath := r.URL.RawPath
+	if routePath == "" {
+		routePath = r.URL.Path
+	}
+
+	var methods []string
+	for _, m := range c.routeMethods {
+		if m == http.MethodOptions {
+			// OPTIONS is answered by this middleware.
+			continue
+		}
+		if rctx.Routes.Match(chi.NewRouteContext(), m, routePath) {
+			methods = append(methods, m)
+		}
+	}
+	if !c.restrictMethods || methods == nil {
+		return methods, true
+	}
+
+	allowed := []string{}
+	for _, m := range methods {
+		if containsString(c.allowedMethods, m) {
+			allowed = append(allowed, m)
+		}
+	}
+	return allowed, true
+}
+
+// addVary adds a value to the Vary header, unless it's already listed.
+func addVary(header http.Header, value string) {
+	for _, v := range header.Values("Vary") {
+		for _, vv := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(vv), value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCORS(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(CORS(CORSOptions{
+		AllowedOrigins: []string{"https://example.com", "https://*.example.org"},
+		AllowOriginFunc: func(r *http.Request, origin string) bool {
+			return origin == "https://trusted.dev"
+		},
+		AllowedHeaders:   []string{"Content-Type", "X-Token"},
+		ExposedHeaders:   []string{"Link"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	}))
+	r.Get("/articles", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("articles"))
+	})
+	r.Route("/articles/{id}", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+		r.Put("/", func(w http.ResponseWriter, r *http.Request) {})
+		r.Delete("/", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		header      http.Header
+		status      int
+		allowOrigin string
+		allowMethod string
+		allowHeader string
+	}{
+		{"actual request", "GET", "/articles", http.Header{"Origin": {"https://example.com"}}, 200, "https://example.com", "", ""},
+		{"wildcard subdomain", "GET", "/articles", http.Header{"Origin": {"https://api.example.org"}}, 200, "https://api.example.org", "", ""},
+		{"origin func", "GET", "/articles", http.Header{"Origin": {"https://trusted.dev"}}, 200, "https://trusted.dev", "", ""},
+		{"disallowed origin", "GET", "/articles", http.Header{"Origin": {"https://evil.com"}}, 200, "", "", ""},
+		{"no origin", "GET", "/articles", nil, 200, "", "", ""},
+		{
+			"preflight with routed methods", "OPTIONS", "/articles/1",
+			http.Header{"Origin": {"https://example.com"}, "Access-Control-Request-Method": {"PUT"}, "Access-Control-Request-Headers": {"content-type, x-token"}},
+			204, "https://example.com", "GET, PUT, DELETE", "Content-Type, X-Token",
+		},
+		{
+			"preflight for unrouted method", "OPTIONS", "/articles",
+			http.Header{"Origin": {"https://example.com"}, "Access-Control-Request-Method": {"DELETE"}},
+			204, "", "", "",
+		},
+		{
+			"preflight with disallowed header", "OPTIONS", "/articles",
+			http.Header{"Origin": {"https://example.com"}, "Access-Control-Request-Method": {"GET"}, "Access-Control-Request-Headers": {"X-Other"}},
+			204, "", "", "",
+		},
+		{
+			"preflight for unknown path", "OPTIONS", "/users",
+			http.Header{"Origin": {"https://example.com"}, "Access-Control-Request-Method": {"GET"}},
+			404, "", "", "",
+		},
+		{
+			"options without origin", "OPTIONS", "/articles/1",
+			http.Header{"Access-Control-Request-Method": {"PUT"}},
+			405, "", "", "",
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Fatalf("%s: expecting status %d but got %d", tt.name, tt.status, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+			t.Fatalf("%s: expecting Access-Control-Allow-Origin '%s' but got '%s'", tt.name, tt.allowOrigin, got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.allowMethod {
+			t.Fatalf("%s: expecting Access-Control-Allow-Methods '%s' but got '%s'", tt.name, tt.allowMethod, got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Headers"); got != tt.allowHeader {
+			t.Fatalf("%s: expecting Access-Control-Allow-Headers '%s' but got '%s'", tt.name, tt.allowHeader, got)
+		}
+		if !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Origin") {
+			t.Fatalf("%s: expecting Vary: Origin", tt.name)
+		}
+		if tt.allowOrigin == "" {
+			continue
+		}
+		if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Fatalf("%s: expecting Access-Control-Allow-Credentials", tt.name)
+		}
+		if tt.method == "OPTIONS" && w.Header().Get("Access-Control-Max-Age") != "300" {
+			t.Fatalf("%s: expecting Access-Control-Max-Age", tt.name)
+		}
+		if tt.method != "OPTIONS" && w.Header().Get("Access-Control-Expose-Headers") != "Link" {
+			t.Fatalf("%s: expecting Access-Control-Expose-Headers", tt.name)
+		}
+	}
+}
+
+func TestCORSAllowedMethods(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(CORS(CORSOptions{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedMethods: []string{"get", "PUT"},
+	}))
+	r.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Put("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Delete("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Delete("/drafts/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	preflight := func(path, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("/articles/1", "PUT")
+	assertEqual(t, http.StatusNoContent, w.Code)
+	assertEqual(t, "GET, PUT", w.Header().Get("Access-Control-Allow-Methods"))
+
+	w = preflight("/articles/1", "DELETE")
+	assertEqual(t, http.StatusNoContent, w.Code)
+	assertEqual(t, "", w.Header().Get("Access-Control-Allow-Methods"))
+	assertEqual(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = preflight("/drafts/1", "DELETE")
+	assertEqual(t, http.StatusNoContent, w.Code)
+	assertEqual(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSSubRouter(t *testing.T) {
+	r := chi.NewRouter()
+	r.Route("/api", func(r chi.Router) {
+		r.Use(CORS(CORSOptions{AllowedOrigins: []string{"https://example.com"}}))
+		r.Get("/items", func(w http.ResponseWriter, r *http.Request) {})
+		r.Post("/items", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	req := httptest.NewRequest("OPTIONS", "/api/items", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assertEqual(t, http.StatusNoContent, w.Code)
+	assertEqual(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assertEqual(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSAllowAll(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(CORS(CORSOptions{AllowedOrigins: []string{"*"}}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://any.com")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("expecting wildcard origin, got '%s'", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Decompress is a middleware that decompresses request bodies of gzip and
+// deflate Content-Encoding, up to maxSize decompressed bytes.
+// See Decompressor.
+func Decompress(maxSize int64) func(next http.Handler) http.Handler {
+	return NewDecompressor(maxSize).Handler
+}
+
+// Decompressor represents a set of request body decoders.
+type Decompressor struct {
+	// The mapping of encoding names to decoder functions.
+	decoders map[string]DecoderFunc
+	// The maximum size of decompressed bodies.
+	maxSize int64
+}
+
+// A DecoderFunc is a function that wraps the provided io.Reader with a
+// streaming decompression algorithm and returns it.
+type DecoderFunc func(r io.Reader) (io.ReadCloser, error)
+
+// NewDecompressor creates a new Decompressor that will decode request bodies
+// of up to maxSize decompressed bytes, with gzip and deflate decoders.
+func NewDecompressor(maxSize int64) *Decompressor {
+	if maxSize < 1 {
+		panic("chi/middleware: Decompressor expects maxSize > 0")
+	}
+	d := &Decompressor{
+		decoders: make(map[string]DecoderFunc),
+		maxSize:  maxSize,
+	}
+	d.SetDecoder("gzip", decoderGzip)
+	d.SetDecoder("x-gzip", decoderGzip)
+	d.SetDecoder("deflate", decoderDeflate)
+	return d
+}
+
+// SetDecoder can be used to set the implementation of a decompression
+// algorithm, for example to support Brotli:
+//
+//  import "github.com/andybalholm/brotli"
+//
+//  decompressor := middleware.NewDecompressor(10 << 20)
+//  decompressor.SetDecoder("br", func(r io.Reader) (io.ReadCloser, error) {
+//    return ioutil.NopCloser(brotli.NewReader(r)), nil
+//  })
+func (d *Decompressor) SetDecoder(encoding string, fn DecoderFunc) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" {
+		panic("the encoding can not be empty")
+	}
+	if fn == nil {
+		panic("attempted to set a nil decoder function")
+	}
+	d.decoders[encoding] = fn
+}
+
+// Handler returns a new middleware that will decompress the request body
+// based on its Content-Encoding header.
+//
+// Handlers see the plain body, with a matching Content-Length and without
+// the Content-Encoding header. Requests with an unsupported encoding are
+// responded with a 415 Unsupported Media Type, bodies that fail to decode
+// with a 400 Bad Request, and bodies decompressing to more than the maximum
+// size with a 413 Request Entity Too Large.
+func (d *Decompressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var encodings []string
+		for _, v := range r.Header.Values("Content-Encoding") {
+			for _, encoding := range strings.Split(v, ",") {
+				encoding = strings.ToLower(strings.TrimSpace(encoding))
+				if encoding != "" && encoding != "identity" {
+					encodings = append(encodings, encoding)
+				}
+			}
+		}
+		if len(encodings) == 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for _, encoding := range encodings {
+			if _, ok := d.decoders[encoding]; !ok {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		body, status := d.decode(r.Body, encodings)
+		r.Body.Close()
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		r.Header.Del("Content-Encoding")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decode decompresses the body, applying the decoders in the reverse order of
+// the encodings. It returns the status code to respond with on failure.
+func (d *Decompressor) decode(body io.Reader, encodings []string) ([]byte, int) {
+	rd := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		dec, err := d.decoders[encodings[i]](rd)
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		defer dec.Close()
+		rd = dec
+	}
+
+	// Read one byte past the limit, to tell a body of exactly maxSize bytes
+	// from a larger one.
+	buf, err := ioutil.ReadAll(io.LimitReader(rd, d.maxSize+1))
+	if err != nil {
+		return nil, http.StatusBadRequest
+	}
+	if int64(len(buf)) > d.maxSize {
+		return nil, http.StatusRequestEntityTooLarge
+	}
+	return buf, 0
+}
+
+func decoderGzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// decoderDeflate decodes both zlib wrapped and raw DEFLATE data, as clients
+// are just as confused about "deflate" as browsers, see NewCompressor.
+func decoderDeflate(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
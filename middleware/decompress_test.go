@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDecompress(t *testing.T) {
+	payload := `{"name":"` + strings.Repeat("chi", 100) + `"}`
+
+	compress := func(wrap func(w io.Writer) io.WriteCloser, data []byte) []byte {
+		var buf bytes.Buffer
+		w := wrap(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+	gz := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	zl := func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+	raw := func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, 5); return fw }
+
+	r := chi.NewRouter()
+	r.Use(Decompress(1024))
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Error("expecting the Content-Encoding header to be removed")
+		}
+		if cl := r.Header.Get("Content-Length"); r.ContentLength != int64(len(body)) || (cl != "" && cl != strconv.Itoa(len(body))) {
+			t.Errorf("expecting Content-Length %d, got %d", len(body), r.ContentLength)
+		}
+		w.Write(body)
+	})
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+	}{
+		{"plain", "", []byte(payload), 200},
+		{"gzip", "gzip", compress(gz, []byte(payload)), 200},
+		{"zlib deflate", "deflate", compress(zl, []byte(payload)), 200},
+		{"raw deflate", "deflate", compress(raw, []byte(payload)), 200},
+		{"stacked", "deflate, gzip", compress(gz, compress(zl, []byte(payload))), 200},
+		{"unsupported", "br", []byte(payload), 415},
+		{"corrupt", "gzip", []byte(payload), 400},
+		{"too large", "gzip", compress(gz, make([]byte, 1025)), 413},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(tc.body))
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("expecting status %d, got %d", tc.status, w.Code)
+			}
+			if tc.status == 200 && w.Body.String() != payload {
+				t.Fatalf("unexpected body '%s'", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagOpts represents a set of ETag options.
+type ETagOpts struct {
+	// Weak generates weak ETags (W/"..."), which only promise semantic
+	// equivalence of the responses.
+	Weak bool
+
+	// MaxBodySize is the largest response body buffered to compute an ETag.
+	// Larger responses are streamed to the client without one. Defaults to
+	// 1 MiB.
+	MaxBodySize int
+
+	// CurrentETag returns the current ETag of the resource of an unsafe
+	// request, such as a PUT or DELETE, and false when the resource doesn't exist.
+	// If-Match and If-None-Match of unsafe requests are ignored when nil.
+	CurrentETag func(r *http.Request) (string, bool)
+
+	// LastModified returns the modification time of the resource of an unsafe
+	// request, and false when it isn't known. If-Unmodified-Since of unsafe
+	// requests is ignored when nil.
+	LastModified func(r *http.Request) (time.Time, bool)
+}
+
+// ETag is a middleware that computes a strong ETag from the hash of the
+// response body of successful GET and HEAD requests, and handles the
+// conditional request headers. See ETagWithOpts.
+func ETag(next http.Handler) http.Handler {
+	return ETagWithOpts(ETagOpts{})(next)
+}
+
+// ETagWithOpts is a middleware that computes ETags from the hash of response
+// bodies using passed ETagOpts, and handles conditional requests:
+//
+//  - GET and HEAD requests matching If-None-Match are responded with a
+//    304 Not Modified, as are requests not modified since If-Modified-Since
+//    when the handler sets a Last-Modified header.
+//  - Unsafe requests, such as PUT or DELETE, with If-Match, If-None-Match or
+//    If-Unmodified-Since are responded with a 412 Precondition Failed when the
+//    current resource doesn't match, as returned by the CurrentETag and
+//    LastModified options. Without them, the preconditions are left to the
+//    handler.
+//
+// ETags set by the handler are kept as is. When the response is compressed,
+// for example by the Compress middleware, the ETag is made weak, as the compressed
+// bytes differ from the identity representation the ETag was computed from.
+func ETagWithOpts(opts ETagOpts) func(next http.Handler) http.Handler {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead:
+				ew := &etagResponseWriter{ResponseWriter: w, opts: opts, code: http.StatusOK}
+				next.ServeHTTP(ew, r)
+				ew.finish(r)
+
+			default:
+				if !checkPreconditions(w, r, opts) {
+					return
+				}
+				next.ServeHTTP(w, r)
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// checkPreconditions evaluates If-Match, If-Unmodified-Since and If-None-Match
+// of an unsafe request against the current validators of the resource, see
+// RFC 7232, section 6. It responds with a 412 Precondition Failed and returns
+// false if they fail.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, opts ETagOpts) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+
+	var etag string
+	var exists bool
+	if opts.CurrentETag != nil && (ifMatch != "" || ifNoneMatch != "") {
+		etag, exists = opts.CurrentETag(r)
+	}
+
+	failed := false
+	switch {
+	case ifMatch != "":
+		failed = opts.CurrentETag != nil && (!exists || !matchETags(ifMatch, etag, true))
+	case ifUnmodifiedSince != "" && opts.LastModified != nil:
+		since, err := http.ParseTime(ifUnmodifiedSince)
+		modified, ok := opts.LastModified(r)
+		failed = err == nil && ok && modified.Truncate(time.Second).After(since)
+	}
+	if !failed && ifNoneMatch != "" && exists {
+		failed = matchETags(ifNoneMatch, etag, false)
+	}
+
+	if failed {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// matchETags reports whether the etag matches any of the entity tags of a
+// conditional header. Strong comparison fails for weak ETags, while weak
+// comparison ignores the weak indicator.
+func matchETags(header, etag string, strong bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return etag != "" || !strong
+	}
+	if etag == "" || (strong && strings.HasPrefix(etag, "W/")) {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if strong && strings.HasPrefix(t, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagResponseWriter buffers the response body to compute its ETag before
+// the headers are sent.
+type etagResponseWriter struct {
+	http.ResponseWriter
+	opts        ETagOpts
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+	passthrough bool
+}
+
+func (ew *etagResponseWriter) WriteHeader(code int) {
+	if ew.passthrough {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	ew.code = code
+	if code != http.StatusOK {
+		ew.startPassthrough()
+	}
+}
+
+func (ew *etagResponseWriter) Write(p []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.passthrough {
+		return ew.ResponseWriter.Write(p)
+	}
+	if ew.buf.Len()+len(p) > ew.opts.MaxBodySize {
+		ew.startPassthrough()
+		return ew.ResponseWriter.Write(p)
+	}
+	return ew.buf.Write(p)
+}
+
+func (ew *etagResponseWriter) Flush() {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	ew.startPassthrough()
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// startPassthrough gives up on the ETag, and sends the buffered response.
+func (ew *etagResponseWriter) startPassthrough() {
+	if ew.passthrough {
+		return
+	}
+	ew.passthrough = true
+	ew.ResponseWriter.WriteHeader(ew.code)
+	if ew.buf.Len() > 0 {
+		ew.ResponseWriter.Write(ew.buf.Bytes())
+		ew.buf.Reset()
+	}
+}
+
+func (ew *etagResponseWriter) finish(r *http.Request) {
+	if ew.passthrough {
+		return
+	}
+
+	header := ew.Header()
+	etag := header.Get("ETag")
+
+	// Handlers usually don't write a body for HEAD requests, which leaves
+	// nothing to compute the ETag from.
+	if etag == "" && !(r.Method == http.MethodHead && ew.buf.Len() == 0) {
+		sum := sha256.Sum256(ew.buf.Bytes())
+		etag = `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+		if ew.opts.Weak || header.Get("Content-Encoding") != "" {
+			etag = "W/" + etag
+		}
+		header.Set("ETag", etag)
+	}
+
+	if notModified(r, etag, header.Get("Last-Modified")) {
+		for _, h := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+			header.Del(h)
+		}
+		ew.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	ew.ResponseWriter.WriteHeader(ew.code)
+	ew.ResponseWriter.Write(ew.buf.Bytes())
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since of a GET or HEAD
+// request, see RFC 7232, section 6.
+func notModified(r *http.Request, etag, lastModified string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return matchETags(inm, etag, false)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
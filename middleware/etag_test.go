@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestETag(t *testing.T) {
+	modified := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	body := "hello etag"
+
+	r := chi.NewRouter()
+	r.Use(ETag)
+	r.Get("/doc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Write([]byte(body))
+	})
+	r.Put("/doc", func(w http.ResponseWriter, r *http.Request) {
+		body = "updated"
+		w.Write([]byte("ok"))
+	})
+	r.Get("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", 404)
+	})
+
+	do := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/doc", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Body.String() != body || !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("unexpected response %d '%s' with ETag %s", w.Code, w.Body.String(), etag)
+	}
+	if w2 := do("GET", "/doc", nil); w2.Header().Get("ETag") != etag {
+		t.Fatal("expecting a stable ETag")
+	}
+
+	// If-None-Match
+	w = do("GET", "/doc", http.Header{"If-None-Match": {`"other", W/` + etag}})
+	if w.Code != 304 || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Fatalf("expecting 304 with ETag, got %d", w.Code)
+	}
+	if w = do("GET", "/doc", http.Header{"If-None-Match": {`"other"`}}); w.Code != 200 {
+		t.Fatalf("expecting 200, got %d", w.Code)
+	}
+
+	// If-Modified-Since
+	if w = do("GET", "/doc", http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}); w.Code != 304 {
+		t.Fatalf("expecting 304, got %d", w.Code)
+	}
+	if w = do("GET", "/doc", http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}); w.Code != 200 {
+		t.Fatalf("expecting 200, got %d", w.Code)
+	}
+
+	// Non-200 responses are left alone.
+	if w = do("GET", "/missing", nil); w.Code != 404 || w.Header().Get("ETag") != "" {
+		t.Fatalf("expecting 404 without ETag, got %d", w.Code)
+	}
+
+	// Preconditions of unsafe requests are left to the handler by default.
+	if w = do("PUT", "/doc", http.Header{"If-Match": {`"stale"`}}); w.Code != 200 || body != "updated" {
+		t.Fatalf("expecting 200 without CurrentETag, got %d", w.Code)
+	}
+}
+
+func TestETagPreconditions(t *testing.T) {
+	modified := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	version := 1
+	exists := true
+	currentETag := func(r *http.Request) (string, bool) {
+		return `"v` + strconv.Itoa(version) + `"`, exists
+	}
+
+	r := chi.NewRouter()
+	r.Use(ETagWithOpts(ETagOpts{
+		CurrentETag: currentETag,
+		LastModified: func(r *http.Request) (time.Time, bool) {
+			return modified, exists
+		},
+	}))
+	r.Put("/doc", func(w http.ResponseWriter, r *http.Request) {
+		version++
+		w.Write([]byte("ok"))
+	})
+	r.Delete("/doc", func(w http.ResponseWriter, r *http.Request) {
+		exists = false
+		w.Write([]byte("ok"))
+	})
+
+	do := func(method string, header http.Header) int {
+		req := httptest.NewRequest(method, "/doc", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// If-Match
+	if code := do("PUT", http.Header{"If-Match": {`"stale"`}}); code != 412 {
+		t.Fatalf("expecting 412, got %d", code)
+	}
+	if code := do("PUT", http.Header{"If-Match": {`W/"v1"`}}); code != 412 {
+		t.Fatalf("expecting 412 for a weak ETag, got %d", code)
+	}
+	if code := do("PUT", http.Header{"If-Match": {`"other", "v1"`}}); code != 200 || version != 2 {
+		t.Fatalf("expecting 200, got %d", code)
+	}
+	if code := do("PUT", http.Header{"If-Match": {`"v1"`}}); code != 412 || version != 2 {
+		t.Fatalf("expecting 412 once the resource changed, got %d", code)
+	}
+
+	// If-Unmodified-Since
+	if code := do("PUT", http.Header{"If-Unmodified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}); code != 412 {
+		t.Fatalf("expecting 412 for If-Unmodified-Since, got %d", code)
+	}
+	if code := do("PUT", http.Header{"If-Unmodified-Since": {modified.Format(http.TimeFormat)}}); code != 200 {
+		t.Fatalf("expecting 200 for If-Unmodified-Since, got %d", code)
+	}
+
+	// If-None-Match
+	if code := do("PUT", http.Header{"If-None-Match": {"*"}}); code != 412 {
+		t.Fatalf("expecting 412 for an existing resource, got %d", code)
+	}
+	if code := do("DELETE", http.Header{"If-Match": {`"v3"`}}); code != 200 || exists {
+		t.Fatalf("expecting 200, got %d", code)
+	}
+	if code := do("PUT", http.Header{"If-Match": {"*"}}); code != 412 {
+		t.Fatalf("expecting 412 for a missing resource, got %d", code)
+	}
+	if code := do("PUT", http.Header{"If-None-Match": {"*"}}); code != 200 {
+		t.Fatalf("expecting 200 for a missing resource, got %d", code)
+	}
+}
+
+func TestETagCompress(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(strings.Repeat("compress me ", 20)))
+	}
+
+	for _, order := range []string{"compress outside", "compress inside"} {
+		r := chi.NewRouter()
+		if order == "compress outside" {
+			r.Use(Compress(5))
+			r.Use(ETag)
+		} else {
+			r.Use(ETag)
+			r.Use(Compress(5))
+		}
+		r.Get("/", handler)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		etag := w.Header().Get("ETag")
+		if w.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("%s: expecting a weak ETag for the compressed response, got '%s'", order, etag)
+		}
+
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != 304 {
+			t.Fatalf("%s: expecting 304, got %d", order, w.Code)
+		}
+	}
+}
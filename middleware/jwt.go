@@ -0,0 +1,402 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// JWTClaimsCtxKey is the context.Context key to store the claims of a
+	// validated JWT.
+	JWTClaimsCtxKey = &contextKey{"JWTClaims"}
+)
+
+// JWTClaims are the claims of a JSON Web Token.
+type JWTClaims map[string]interface{}
+
+// Subject returns the "sub" claim.
+func (c JWTClaims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// Issuer returns the "iss" claim.
+func (c JWTClaims) Issuer() string {
+	s, _ := c["iss"].(string)
+	return s
+}
+
+// Audience returns the "aud" claim, which is either a string or an array of
+// strings.
+func (c JWTClaims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var auds []string
+		for _, v := range aud {
+			if s, ok := v.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+		return auds
+	}
+	return nil
+}
+
+// numericDate returns a NumericDate claim, such as "exp" or "nbf".
+func (c JWTClaims) numericDate(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	secs, ok := v.(float64)
+	// Between year 1 and year 9999, which keeps the time arithmetics of the
+	// checks from overflowing.
+	if !ok || math.IsNaN(secs) || secs < -62135596800 || secs > 253402300799 {
+		return time.Time{}, false, fmt.Errorf("invalid %s claim", name)
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))), true, nil
+}
+
+// GetJWTClaims returns the claims of the JWT validated by the JWT middleware.
+func GetJWTClaims(ctx context.Context) JWTClaims {
+	if claims, ok := ctx.Value(JWTClaimsCtxKey).(JWTClaims); ok {
+		return claims
+	}
+	return nil
+}
+
+// JWTKeyProvider provides the keys to verify the signature of JWTs with.
+type JWTKeyProvider interface {
+	// JWTKey returns the key for the algorithm and key ID in the header of a
+	// token. The key is a []byte for HMAC algorithms, a *rsa.PublicKey for
+	// RS256 or a *ecdsa.PublicKey for ES256.
+	JWTKey(alg, kid string) (interface{}, error)
+}
+
+// JWTKeyProviderFunc is an adapter to use a function as a JWTKeyProvider,
+// for example to fetch keys from a live JWKS endpoint.
+type JWTKeyProviderFunc func(alg, kid string) (interface{}, error)
+
+// JWTKey calls f(alg, kid).
+func (f JWTKeyProviderFunc) JWTKey(alg, kid string) (interface{}, error) {
+	return f(alg, kid)
+}
+
+// JWTKeySet is an in-memory JWTKeyProvider of keys by key ID. Tokens without a
+// key ID are verified with the key of the empty ID, or the only key of the set.
+type JWTKeySet map[string]interface{}
+
+// JWTKey returns the key of the kid key ID.
+func (ks JWTKeySet) JWTKey(alg, kid string) (interface{}, error) {
+	if key, ok := ks[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(ks) == 1 {
+		for _, key := range ks {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// LoadJWKS parses a JSON Web Key Set into a JWTKeySet. It supports RSA,
+// P-256 EC and symmetric ("oct") keys.
+func LoadJWKS(data []byte) (JWTKeySet, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	ks := JWTKeySet{}
+	for _, k := range jwks.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err1 := decodeJWKInt(k.N)
+			e, err2 := decodeJWKInt(k.E)
+			if err1 != nil || err2 != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("chi/middleware: invalid RSA key %q", k.Kid)
+			}
+			ks[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+		case "EC":
+			if k.Crv != "P-256" {
+				return nil, fmt.Errorf("chi/middleware: unsupported EC curve %q of key %q", k.Crv, k.Kid)
+			}
+			x, err1 := decodeJWKInt(k.X)
+			y, err2 := decodeJWKInt(k.Y)
+			if err1 != nil || err2 != nil || !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("chi/middleware: invalid EC key %q", k.Kid)
+			}
+			ks[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+
+		case "oct":
+			key, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(key) == 0 {
+				return nil, fmt.Errorf("chi/middleware: invalid symmetric key %q", k.Kid)
+			}
+			ks[k.Kid] = key
+
+		default:
+			return nil, fmt.Errorf("chi/middleware: unsupported key type %q of key %q", k.Kty, k.Kid)
+		}
+	}
+	return ks, nil
+}
+
+// LoadJWKSFile reads and parses a JSON Web Key Set file. See LoadJWKS.
+func LoadJWKSFile(filename string) (JWTKeySet, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return LoadJWKS(data)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWTOpts represents a set of JWT authentication options.
+type JWTOpts struct {
+	// Keys provides the keys to verify the token signatures with.
+	Keys JWTKeyProvider
+
+	// Algorithms are the accepted signature algorithms. Defaults to all the
+	// supported ones: HS256, HS384, HS512, RS256 and ES256.
+	Algorithms []string
+
+	// Issuer is the expected "iss" claim, if any.
+	Issuer string
+
+	// Audience is the value the "aud" claim must contain, if any.
+	Audience string
+
+	// Leeway is the clock skew tolerated when checking "exp" and "nbf".
+	Leeway time.Duration
+
+	// Realm is the realm of the WWW-Authenticate challenge, if any.
+	Realm string
+}
+
+// JWT is a middleware that authenticates requests with a JWT bearer token
+// signed with one of the keys. See JWTWithOpts.
+func JWT(keys JWTKeyProvider) func(next http.Handler) http.Handler {
+	return JWTWithOpts(JWTOpts{Keys: keys})
+}
+
+// JWTWithOpts is a middleware that authenticates requests with a JSON Web
+// Token in the Authorization header, using passed JWTOpts.
+//
+// The token signature is verified with the key of the provider for the "alg"
+// and "kid" of its header, and its "exp", "nbf", "iss" and "aud" claims are
+// checked. The claims of valid tokens are stored in the request context,
+// see GetJWTClaims. Other requests are responded with a 401 Unauthorized and
+// a WWW-Authenticate Bearer challenge, see RFC 6750.
+func JWTWithOpts(opts JWTOpts) func(next http.Handler) http.Handler {
+	if opts.Keys == nil {
+		panic("chi/middleware: JWT expects a key provider")
+	}
+	if len(opts.Algorithms) == 0 {
+		opts.Algorithms = []string{"HS256", "HS384", "HS512", "RS256", "ES256"}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+				jwtAuthFailed(w, opts.Realm, "", "")
+				return
+			}
+
+			claims, err := verifyJWT(strings.TrimSpace(auth[7:]), opts, time.Now())
+			if err != nil {
+				jwtAuthFailed(w, opts.Realm, "invalid_token", err.Error())
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), JWTClaimsCtxKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// verifyJWT verifies the signature and claims of a token in JWS compact
+// serialization.
+func verifyJWT(token string, opts JWTOpts, now time.Time) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg  string          `json:"alg"`
+		Kid  string          `json:"kid"`
+		Crit json.RawMessage `json:"crit"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	// No extension is understood, so tokens with critical ones are rejected,
+	// see RFC 7515 section 4.1.11.
+	if header.Crit != nil {
+		return nil, errors.New("unsupported critical header parameters")
+	}
+	if !containsString(opts.Algorithms, header.Alg) {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	// The errors of the key provider are internal, and aren't disclosed.
+	key, err := opts.Keys.JWTKey(header.Alg, header.Kid)
+	if err != nil {
+		return nil, errors.New("unknown signing key")
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, errors.New("malformed token claims")
+	}
+
+	exp, ok, err := claims.numericDate("exp")
+	if err != nil {
+		return nil, err
+	}
+	if ok && !now.Before(exp.Add(opts.Leeway)) {
+		return nil, errors.New("token is expired")
+	}
+	nbf, ok, err := claims.numericDate("nbf")
+	if err != nil {
+		return nil, err
+	}
+	if ok && now.Add(opts.Leeway).Before(nbf) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if opts.Issuer != "" && claims.Issuer() != opts.Issuer {
+		return nil, errors.New("invalid issuer")
+	}
+	if opts.Audience != "" && !containsString(claims.Audience(), opts.Audience) {
+		return nil, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWTSignature verifies the signature of the signing input. The key
+// type must match the algorithm, so that a public RSA key, for instance, can't
+// be used as an HMAC secret.
+func verifyJWTSignature(alg string, key interface{}, input string, sig []byte) error {
+	invalid := errors.New("invalid signature")
+
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return invalid
+		}
+		var h func() hash.Hash
+		switch alg {
+		case "HS256":
+			h = sha256.New
+		case "HS384":
+			h = sha512.New384
+		default:
+			h = sha512.New
+		}
+		mac := hmac.New(h, secret)
+		mac.Write([]byte(input))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return invalid
+		}
+		return nil
+
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return invalid
+		}
+		sum := sha256.Sum256([]byte(input))
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) != nil {
+			return invalid
+		}
+		return nil
+
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(sig) != 64 {
+			return invalid
+		}
+		sum := sha256.Sum256([]byte(input))
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, sum[:], r, s) {
+			return invalid
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+func jwtAuthFailed(w http.ResponseWriter, realm, code, description string) {
+	var params []string
+	if realm != "" {
+		params = append(params, fmt.Sprintf(`realm="%s"`, realm))
+	}
+	if code != "" {
+		params = append(params, fmt.Sprintf(`error="%s"`, code))
+		params = append(params, fmt.Sprintf(`error_description="%s"`, strings.Replace(description, `"`, `'`, -1)))
+	}
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	w.Header().Add("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+}
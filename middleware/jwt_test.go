@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	return signJWTHeader(t, header, key, claims)
+}
+
+func signJWTHeader(t *testing.T, header map[string]interface{}, key interface{}, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := enc(header) + "." + enc(claims)
+	sum := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("s3cr3t")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	keys := JWTKeySet{
+		"hmac": secret,
+		"rsa":  &rsaKey.PublicKey,
+		"ec":   &ecKey.PublicKey,
+	}
+
+	r := chi.NewRouter()
+	r.Use(JWTWithOpts(JWTOpts{Keys: keys, Issuer: "chi", Audience: "api", Realm: "test"}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetJWTClaims(r.Context()).Subject()))
+	})
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "peter", "iss": "chi", "aud": []string{"web", "api"}, "exp": now + 60}
+	with := func(k string, v interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for kk, vv := range valid {
+			claims[kk] = vv
+		}
+		claims[k] = v
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		error  string
+	}{
+		{"HS256", signJWT(t, "HS256", "hmac", secret, valid), 200, ""},
+		{"RS256", signJWT(t, "RS256", "rsa", rsaKey, valid), 200, ""},
+		{"ES256", signJWT(t, "ES256", "ec", ecKey, valid), 200, ""},
+		{"missing token", "", 401, ""},
+		{"malformed", "abc.def", 401, "malformed token"},
+		{"bad signature", signJWT(t, "HS256", "hmac", []byte("other"), valid), 401, "invalid signature"},
+		{"key type confusion", signJWT(t, "HS256", "rsa", secret, valid), 401, "invalid signature"},
+		{"unknown key", signJWT(t, "HS256", "nope", secret, valid), 401, "unknown signing key"},
+		{"none algorithm", signJWT(t, "none", "hmac", nil, valid), 401, "unsupported algorithm 'none'"},
+		{"expired", signJWT(t, "HS256", "hmac", secret, with("exp", now-10)), 401, "token is expired"},
+		{"not valid yet", signJWT(t, "HS256", "hmac", secret, with("nbf", now+60)), 401, "token is not valid yet"},
+		{"wrong issuer", signJWT(t, "HS256", "hmac", secret, with("iss", "other")), 401, "invalid issuer"},
+		{"fractional expiry", signJWT(t, "HS256", "hmac", secret, with("exp", float64(now)+60.5)), 200, ""},
+		{"overflowing not before", signJWT(t, "HS256", "hmac", secret, with("nbf", 1e19)), 401, "invalid nbf claim"},
+		{"overflowing expiry", signJWT(t, "HS256", "hmac", secret, with("exp", 1e19)), 401, "invalid exp claim"},
+		{"critical extension", signJWTHeader(t, map[string]interface{}{"alg": "HS256", "kid": "hmac", "crit": []string{"exp"}, "exp": now}, secret, valid), 401, "unsupported critical header parameters"},
+		{"wrong audience", signJWT(t, "HS256", "hmac", secret, with("aud", "web")), 401, "invalid audience"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("expecting status %d, got %d: %s", tc.status, w.Code, w.Header().Get("WWW-Authenticate"))
+			}
+			switch {
+			case tc.status == 200:
+				assertEqual(t, "peter", w.Body.String())
+			case tc.error == "":
+				assertEqual(t, `Bearer realm="test"`, w.Header().Get("WWW-Authenticate"))
+			default:
+				want := fmt.Sprintf(`Bearer realm="test", error="invalid_token", error_description="%s"`, tc.error)
+				assertEqual(t, want, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa","n":"%s","e":"%s"},
+		{"kty":"EC","kid":"ec","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"oct","kid":"hmac","k":"%s"}
+	]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(ecKey.X.Bytes()), b64(ecKey.Y.Bytes()),
+		b64([]byte("s3cr3t")))
+
+	keys, err := LoadJWKS([]byte(jwks))
+	assertNoError(t, err)
+
+	claims := map[string]interface{}{"sub": "peter"}
+	for _, token := range []string{
+		signJWT(t, "RS256", "rsa", rsaKey, claims),
+		signJWT(t, "ES256", "ec", ecKey, claims),
+		signJWT(t, "HS256", "hmac", []byte("s3cr3t"), claims),
+	} {
+		got, err := verifyJWT(token, JWTOpts{Keys: keys, Algorithms: []string{"HS256", "RS256", "ES256"}}, time.Now())
+		assertNoError(t, err)
+		assertEqual(t, "peter", got.Subject())
+	}
+
+	if _, err := LoadJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-521"}]}`)); err == nil {
+		t.Fatal("expecting an error for an unsupported curve")
+	}
+}
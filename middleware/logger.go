@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"context"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"runtime"
+	"strings"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 var (
@@ -44,14 +48,120 @@ func Logger(next http.Handler) http.Handler {
 
 // RequestLogger returns a logger handler using a custom LogFormatter.
 func RequestLogger(f LogFormatter) func(next http.Handler) http.Handler {
+	return RequestLoggerWithOpts(f, RequestLoggerOpts{})
+}
+
+// LogLevel is the level of a request log entry.
+type LogLevel int
+
+const (
+	LogLevelInfo LogLevel = iota
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// LogDetails are passed as the extra argument of LogEntry.Write by the
+// RequestLoggerWithOpts middleware.
+type LogDetails struct {
+	Level LogLevel
+
+	// Slow reports whether the request took longer than the SlowThreshold.
+	Slow bool
+}
+
+// RequestLoggerOpts represents a set of request logger options.
+type RequestLoggerOpts struct {
+	// SkipRoutes are the route patterns of requests not to log, such as
+	// "/metrics". Patterns are matched once the handler returns, so they must
+	// be the full patterns, including the prefixes of mounted sub-routers.
+	SkipRoutes []string
+
+	// SkipPaths are the path prefixes of requests not to log, such as "/ping".
+	SkipPaths []string
+
+	// SampleRate is the fraction, between 0 and 1, of successful requests to
+	// log. Client and server errors are always logged. Zero logs all requests.
+	SampleRate float64
+
+	// SlowThreshold is the duration over which requests are flagged as slow,
+	// and logged regardless of the SampleRate. Zero disables it.
+	SlowThreshold time.Duration
+
+	// Level returns the level of a request by its status. Defaults to info for
+	// 1xx to 3xx, warn for 4xx and error for 5xx statuses.
+	Level func(status int) LogLevel
+}
+
+// RequestLoggerWithOpts returns a logger handler using a custom LogFormatter
+// and passed RequestLoggerOpts. When a SampleRate, SlowThreshold or Level is
+// set, the level and slow flag of the requests are passed to the log entries
+// as *LogDetails. Otherwise, the extra argument is nil, as with RequestLogger.
+//
+//  r.Use(middleware.RequestLoggerWithOpts(formatter, middleware.RequestLoggerOpts{
+//    SkipPaths:     []string{"/ping"},
+//    SkipRoutes:    []string{"/metrics"},
+//    SampleRate:    0.1,
+//    SlowThreshold: time.Second,
+//  }))
+func RequestLoggerWithOpts(f LogFormatter, opts RequestLoggerOpts) func(next http.Handler) http.Handler {
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		panic("chi/middleware: RequestLogger expects a sample rate between 0 and 1")
+	}
+	withDetails := opts.SampleRate > 0 || opts.SlowThreshold > 0 || opts.Level != nil
+	if opts.Level == nil {
+		opts.Level = defaultLogLevel
+	}
+	skipRoutes := make(map[string]bool, len(opts.SkipRoutes))
+	for _, pattern := range opts.SkipRoutes {
+		skipRoutes[pattern] = true
+	}
+
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range opts.SkipPaths {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
 			entry := f.NewLogEntry(r)
 			ww := NewWrapResponseWriter(w, r.ProtoMajor)
 
 			t1 := time.Now()
 			defer func() {
-				entry.Write(ww.Status(), ww.BytesWritten(), ww.Header(), time.Since(t1), nil)
+				elapsed := time.Since(t1)
+				if len(skipRoutes) > 0 {
+					if rctx := chi.RouteContext(r.Context()); rctx != nil && skipRoutes[rctx.RoutePattern()] {
+						return
+					}
+				}
+
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				slow := opts.SlowThreshold > 0 && elapsed >= opts.SlowThreshold
+				if opts.SampleRate > 0 && status < 400 && !slow && rand.Float64() >= opts.SampleRate {
+					return
+				}
+
+				var extra interface{}
+				if withDetails {
+					extra = &LogDetails{Level: opts.Level(status), Slow: slow}
+				}
+				entry.Write(ww.Status(), ww.BytesWritten(), ww.Header(), elapsed, extra)
 			}()
 
 			next.ServeHTTP(ww, WithLogEntry(r, entry))
@@ -60,6 +170,17 @@ func RequestLogger(f LogFormatter) func(next http.Handler) http.Handler {
 	}
 }
 
+func defaultLogLevel(status int) LogLevel {
+	switch {
+	case status >= 500:
+		return LogLevelError
+	case status >= 400:
+		return LogLevelWarn
+	default:
+		return LogLevelInfo
+	}
+}
+
 // LogFormatter initiates the beginning of a new LogEntry per request.
 // See DefaultLogFormatter for an example implementation.
 type LogFormatter interface {
@@ -112,6 +233,7 @@ func (l *DefaultLogFormatter) NewLogEntry(r *http.Request) LogEntry {
 	}
 	cW(entry.buf, useColor, nCyan, "\"")
 	cW(entry.buf, useColor, bMagenta, "%s ", r.Method)
+	entry.methodEnd = entry.buf.Len()
 
 	scheme := "http"
 	if r.TLS != nil {
@@ -128,9 +250,10 @@ func (l *DefaultLogFormatter) NewLogEntry(r *http.Request) LogEntry {
 
 type defaultLogEntry struct {
 	*DefaultLogFormatter
-	request  *http.Request
-	buf      *bytes.Buffer
-	useColor bool
+	request   *http.Request
+	buf       *bytes.Buffer
+	useColor  bool
+	methodEnd int
 }
 
 func (l *defaultLogEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
@@ -157,6 +280,18 @@ func (l *defaultLogEntry) Write(status, bytes int, header http.Header, elapsed t
 	} else {
 		cW(l.buf, l.useColor, nRed, "%s", elapsed)
 	}
+	if details, ok := extra.(*LogDetails); ok && details.Slow {
+		cW(l.buf, l.useColor, bRed, " (slow)")
+	}
+
+	// Show the effective method next to the wire method when it was
+	// overridden by the MethodOverride middleware.
+	if m := GetMethodOverride(l.request); m != "" {
+		tail := append([]byte(nil), l.buf.Bytes()[l.methodEnd:]...)
+		l.buf.Truncate(l.methodEnd)
+		cW(l.buf, l.useColor, bMagenta, "(%s) ", m)
+		l.buf.Write(tail)
+	}
 
 	l.Logger.Print(l.buf.String())
 }
@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CommonLogFormat is the NCSA Common Log Format.
+	CommonLogFormat = `%h %l %u %t "%r" %>s %b`
+
+	// CombinedLogFormat is the NCSA Combined Log Format, the Common Log Format
+	// with the referer and user agent of requests.
+	CombinedLogFormat = CommonLogFormat + ` "%{Referer}i" "%{User-Agent}i"`
+)
+
+// apacheLogFlushInterval is how long log lines are buffered at most.
+const apacheLogFlushInterval = time.Second
+
+// ApacheLogFormatter is a LogFormatter writing access logs in the format of
+// Apache's mod_log_config, such as CommonLogFormat or CombinedLogFormat:
+//
+//  accessLog := middleware.NewApacheLogFormatter(f, middleware.CombinedLogFormat)
+//  defer accessLog.Flush()
+//
+//  r.Use(middleware.RequestLogger(accessLog))
+//
+// The supported directives are:
+//
+//  %%         a literal percent sign
+//  %h         the client IP
+//  %l         the remote logname, always "-"
+//  %u         the basic auth user
+//  %t         the time the request was received
+//  %r         the request line, e.g. "GET /path HTTP/1.1"
+//  %s, %>s    the response status
+//  %b         the response size in bytes, "-" when empty
+//  %B         the response size in bytes
+//  %D         the duration in microseconds
+//  %T         the duration in seconds
+//  %m         the request method
+//  %U         the request path
+//  %q         the query string, prefixed with "?" when not empty
+//  %H         the request protocol
+//  %v         the request host
+//  %{Name}i   a request header
+//  %{Name}o   a response header
+//
+// Log lines are buffered, and flushed to the writer at least every second.
+// Call Flush before exiting to not lose the last lines.
+type ApacheLogFormatter struct {
+	directives []apacheLogDirective
+
+	mu    sync.Mutex
+	buf   *bufio.Writer
+	timer *time.Timer
+}
+
+// NewApacheLogFormatter returns an ApacheLogFormatter writing to w with the
+// format. It panics on unknown directives.
+func NewApacheLogFormatter(w io.Writer, format string) *ApacheLogFormatter {
+	directives, err := parseApacheLogFormat(format)
+	if err != nil {
+		panic(fmt.Sprintf("chi/middleware: %v", err))
+	}
+	return &ApacheLogFormatter{
+		directives: directives,
+		buf:        bufio.NewWriter(w),
+	}
+}
+
+// NewLogEntry creates a new LogEntry for the request.
+func (l *ApacheLogFormatter) NewLogEntry(r *http.Request) LogEntry {
+	return &apacheLogEntry{ApacheLogFormatter: l, request: r, start: time.Now()}
+}
+
+// Flush writes the buffered log lines to the writer.
+func (l *ApacheLogFormatter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Flush()
+}
+
+func (l *ApacheLogFormatter) write(b []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(b)
+	if l.buf.Buffered() > 0 && l.timer == nil {
+		l.timer = time.AfterFunc(apacheLogFlushInterval, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.timer = nil
+			l.buf.Flush()
+		})
+	}
+}
+
+type apacheLogEntry struct {
+	*ApacheLogFormatter
+	request *http.Request
+	start   time.Time
+}
+
+func (l *apacheLogEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	r := l.request
+
+	b := make([]byte, 0, 256)
+	for _, d := range l.directives {
+		switch d.verb {
+		case 0:
+			b = append(b, d.arg...)
+		case 'h':
+			b = append(b, KeyByIP(r)...)
+		case 'l':
+			b = append(b, '-')
+		case 'u':
+			user, _, _ := r.BasicAuth()
+			b = appendApacheLogValue(b, user)
+		case 't':
+			b = l.start.AppendFormat(append(b, '['), "02/Jan/2006:15:04:05 -0700")
+			b = append(b, ']')
+		case 'r':
+			b = appendApacheLogValue(b, r.Method+" "+r.RequestURI+" "+r.Proto)
+		case 's':
+			b = strconv.AppendInt(b, int64(status), 10)
+		case 'b':
+			if bytes == 0 {
+				b = append(b, '-')
+			} else {
+				b = strconv.AppendInt(b, int64(bytes), 10)
+			}
+		case 'B':
+			b = strconv.AppendInt(b, int64(bytes), 10)
+		case 'D':
+			b = strconv.AppendInt(b, elapsed.Microseconds(), 10)
+		case 'T':
+			b = strconv.AppendInt(b, int64(elapsed/time.Second), 10)
+		case 'm':
+			b = appendApacheLogValue(b, r.Method)
+		case 'U':
+			b = appendApacheLogValue(b, r.URL.Path)
+		case 'q':
+			if r.URL.RawQuery != "" {
+				b = appendApacheLogValue(b, "?"+r.URL.RawQuery)
+			}
+		case 'H':
+			b = appendApacheLogValue(b, r.Proto)
+		case 'v':
+			b = appendApacheLogValue(b, r.Host)
+		case 'i':
+			b = appendApacheLogValue(b, r.Header.Get(d.arg))
+		case 'o':
+			b = appendApacheLogValue(b, header.Get(d.arg))
+		}
+	}
+	l.write(append(b, '\n'))
+}
+
+func (l *apacheLogEntry) Panic(v interface{}, stack []byte) {
+	PrintPrettyStack(v)
+}
+
+// apacheLogDirective is a directive of a log format, or a literal string when
+// verb is 0.
+type apacheLogDirective struct {
+	verb byte
+	arg  string
+}
+
+func parseApacheLogFormat(format string) ([]apacheLogDirective, error) {
+	var directives []apacheLogDirective
+	literal := func(s string) {
+		if n := len(directives); n > 0 && directives[n-1].verb == 0 {
+			directives[n-1].arg += s
+		} else {
+			directives = append(directives, apacheLogDirective{arg: s})
+		}
+	}
+
+	for len(format) > 0 {
+		i := strings.IndexByte(format, '%')
+		if i < 0 {
+			literal(format)
+			break
+		}
+		literal(format[:i])
+		format = format[i+1:]
+
+		var arg string
+		if strings.HasPrefix(format, "{") {
+			end := strings.IndexByte(format, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated log format directive '%%%s'", format)
+			}
+			arg, format = format[1:end], format[end+1:]
+		}
+		// Apache's final status modifier, "%>s", is the only status we know.
+		format = strings.TrimPrefix(format, ">")
+		if format == "" {
+			return nil, fmt.Errorf("incomplete log format directive")
+		}
+
+		verb := format[0]
+		format = format[1:]
+		switch verb {
+		case '%':
+			literal("%")
+		case 'i', 'o':
+			if arg == "" {
+				return nil, fmt.Errorf("log format directive '%%%c' expects a header name", verb)
+			}
+			directives = append(directives, apacheLogDirective{verb: verb, arg: arg})
+		case 'h', 'l', 'u', 't', 'r', 's', 'b', 'B', 'D', 'T', 'm', 'U', 'q', 'H', 'v':
+			directives = append(directives, apacheLogDirective{verb: verb})
+		default:
+			return nil, fmt.Errorf("unknown log format directive '%%%c'", verb)
+		}
+	}
+	return directives, nil
+}
+
+// appendApacheLogValue appends a value escaped the way Apache does, with
+// quotes, backslashes and non printable characters escaped, and "-" for
+// empty values.
+func appendApacheLogValue(b []byte, v string) []byte {
+	if v == "" {
+		return append(b, '-')
+	}
+	const hexDigits = "0123456789abcdef"
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < 0x20 || c >= 0x7f:
+			b = append(b, '\\', 'x', hexDigits[c>>4], hexDigits[c&0xf])
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
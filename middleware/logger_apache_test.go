@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestApacheLogFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewApacheLogFormatter(&buf, CombinedLogFormat+` %{X-Cache}o %m%U%q %%`)
+
+	handler := RequestLogger(f)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest("GET", "/users?q=1", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.SetBasicAuth("frank", "secret")
+	req.Header.Set("User-Agent", `curl "7.0"`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assertEqual(t, "", buf.String())
+	assertNoError(t, f.Flush())
+
+	re := regexp.MustCompile(`^10\.0\.0\.1 - frank \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users\?q=1 HTTP/1\.1" 200 5 "-" "curl \\"7\.0\\"" HIT GET/users\?q=1 %\n$`)
+	if !re.MatchString(buf.String()) {
+		t.Fatalf("unexpected log line %q", buf.String())
+	}
+}
+
+func TestApacheLogFormatterConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewApacheLogFormatter(&buf, CommonLogFormat)
+	handler := RequestLogger(f)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/", nil))
+		}()
+	}
+	wg.Wait()
+	assertNoError(t, f.Flush())
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assertEqual(t, 50, len(lines))
+	for _, line := range lines {
+		if !strings.HasSuffix(line, `"DELETE / HTTP/1.1" 204 -`) {
+			t.Fatalf("unexpected log line %q", line)
+		}
+	}
+}
+
+func TestParseApacheLogFormat(t *testing.T) {
+	for _, format := range []string{"%z", "%{Referer", "%i", "100%"} {
+		if _, err := parseApacheLogFormat(format); err == nil {
+			t.Errorf("expecting an error for %q", format)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Fields of the JSONLogFormatter log entries.
+const (
+	LogFieldTimestamp = "timestamp"
+	LogFieldRequestID = "request_id"
+	LogFieldMethod    = "method"
+	LogFieldRoute     = "route"
+	LogFieldPath      = "path"
+	LogFieldStatus    = "status"
+	LogFieldBytes     = "bytes"
+	LogFieldDuration  = "duration"
+	LogFieldRemoteIP  = "remote_ip"
+	LogFieldUserAgent = "user_agent"
+	LogFieldLevel     = "level"
+)
+
+// DefaultLogFields are the fields logged by a JSONLogFormatter with no Fields.
+var DefaultLogFields = []string{
+	LogFieldTimestamp, LogFieldRequestID, LogFieldMethod, LogFieldRoute,
+	LogFieldPath, LogFieldStatus, LogFieldBytes, LogFieldDuration,
+	LogFieldRemoteIP, LogFieldUserAgent, LogFieldLevel,
+}
+
+// JSONLogFormatter is a LogFormatter writing one JSON object per request,
+// for log pipelines that expect structured logs:
+//
+//  r.Use(middleware.RequestLogger(&middleware.JSONLogFormatter{
+//    Fields:  []string{middleware.LogFieldMethod, middleware.LogFieldPath, middleware.LogFieldStatus},
+//    Headers: []string{"Referer"},
+//  }))
+//
+// The duration is logged in seconds, and the timestamp is the start of the
+// request. The method of requests overridden by MethodOverride is logged
+// under "method_override", next to the wire method. The level, and the "slow"
+// flag of slow requests, are the ones of the RequestLoggerWithOpts options,
+// the level defaulting to the one of the status. Handlers can add fields to
+// the log entry, see SetLogField.
+type JSONLogFormatter struct {
+	// Writer receives the log lines. Defaults to os.Stdout.
+	Writer io.Writer
+
+	// Fields are the logged fields, see the LogField constants. Defaults to
+	// DefaultLogFields.
+	Fields []string
+
+	// Headers are the request headers to log, under the "headers" field.
+	Headers []string
+
+	mu sync.Mutex
+}
+
+// NewLogEntry creates a new JSONLogEntry for the request.
+func (l *JSONLogFormatter) NewLogEntry(r *http.Request) LogEntry {
+	return &JSONLogEntry{
+		formatter: l,
+		request:   r,
+		start:     time.Now(),
+		extra:     make(map[string]interface{}),
+	}
+}
+
+func (l *JSONLogFormatter) write(b []byte) {
+	w := l.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	l.mu.Lock()
+	w.Write(append(b, '\n'))
+	l.mu.Unlock()
+}
+
+// JSONLogEntry is the LogEntry of a JSONLogFormatter.
+type JSONLogEntry struct {
+	formatter *JSONLogFormatter
+	request   *http.Request
+	start     time.Time
+
+	mu    sync.Mutex
+	extra map[string]interface{}
+}
+
+// Set adds a field to the log entry. Fields set by handlers take precedence
+// over the fields of the formatter.
+func (l *JSONLogEntry) Set(key string, value interface{}) {
+	l.mu.Lock()
+	l.extra[key] = value
+	l.mu.Unlock()
+}
+
+// Write writes the log entry.
+func (l *JSONLogEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
+	r := l.request
+	fields := l.formatter.Fields
+	if len(fields) == 0 {
+		fields = DefaultLogFields
+	}
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+	details, _ := extra.(*LogDetails)
+	out := make(map[string]interface{}, len(fields)+len(l.extra)+1)
+	for _, field := range fields {
+		switch field {
+		case LogFieldTimestamp:
+			out[field] = l.start.UTC().Format(time.RFC3339Nano)
+		case LogFieldRequestID:
+			if reqID := GetReqID(r.Context()); reqID != "" {
+				out[field] = reqID
+			}
+		case LogFieldMethod:
+			out[field] = r.Method
+			if m := GetMethodOverride(r); m != "" {
+				out["method_override"] = m
+			}
+		case LogFieldRoute:
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				out[field] = rctx.RoutePattern()
+			}
+		case LogFieldPath:
+			out[field] = r.URL.Path
+		case LogFieldStatus:
+			out[field] = status
+		case LogFieldBytes:
+			out[field] = bytes
+		case LogFieldDuration:
+			out[field] = elapsed.Seconds()
+		case LogFieldRemoteIP:
+			out[field] = KeyByIP(r)
+		case LogFieldUserAgent:
+			out[field] = r.UserAgent()
+		case LogFieldLevel:
+			if details != nil {
+				out[field] = details.Level.String()
+			} else {
+				out[field] = defaultLogLevel(status).String()
+			}
+		}
+	}
+	if len(l.formatter.Headers) > 0 {
+		headers := make(map[string]string, len(l.formatter.Headers))
+		for _, name := range l.formatter.Headers {
+			if v := r.Header.Get(name); v != "" {
+				headers[name] = v
+			}
+		}
+		out["headers"] = headers
+	}
+	if details != nil {
+		if details.Slow {
+			out["slow"] = true
+		}
+	} else if extra != nil {
+		out["extra"] = extra
+	}
+
+	l.mu.Lock()
+	for k, v := range l.extra {
+		out[k] = v
+	}
+	b, err := json.Marshal(out)
+	l.mu.Unlock()
+	if err != nil {
+		// An unmarshalable field, such as a channel, shouldn't lose the log.
+		b, _ = json.Marshal(map[string]interface{}{"log_error": err.Error()})
+	}
+	l.formatter.write(b)
+}
+
+// Panic records the panic value and its stack frames, under the "panic" and
+// "stack" fields.
+func (l *JSONLogEntry) Panic(v interface{}, stack []byte) {
+	l.Set("panic", fmt.Sprint(v))
+	l.Set("stack", parseStack(stack))
+}
+
+// SetLogField adds a field to the in-context log entry of a request, when it
+// supports it, as a JSONLogEntry does.
+func SetLogField(r *http.Request, key string, value interface{}) {
+	if entry, ok := GetLogEntry(r).(interface {
+		Set(key string, value interface{})
+	}); ok {
+		entry.Set(key, value)
+	}
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestJSONLogFormatter(t *testing.T) {
+	var buf bytes.Buffer
+
+	r := chi.NewRouter()
+	r.Use(RequestID)
+	r.Use(RequestLogger(&JSONLogFormatter{Writer: &buf, Headers: []string{"Referer", "X-Missing"}}))
+	r.Use(Recoverer)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		SetLogField(r, "user", chi.URLParam(r, "id"))
+		w.Write([]byte("hi"))
+	})
+	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("User-Agent", "test")
+	req.Header.Set("Referer", "http://example.com")
+	req.RemoteAddr = "10.0.0.1:1234"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertEqual(t, 2, len(lines))
+
+	var entry map[string]interface{}
+	assertNoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	for field, want := range map[string]interface{}{
+		"method":     "GET",
+		"route":      "/users/{id}",
+		"path":       "/users/1",
+		"status":     float64(200),
+		"bytes":      float64(2),
+		"remote_ip":  "10.0.0.1",
+		"user_agent": "test",
+		"user":       "1",
+		"level":      "info",
+		"headers":    map[string]interface{}{"Referer": "http://example.com"},
+	} {
+		assertEqual(t, want, entry[field])
+	}
+	for _, field := range []string{"timestamp", "request_id", "duration"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("expecting field %q, got %s", field, lines[0])
+		}
+	}
+
+	var panicEntry struct {
+		Status int          `json:"status"`
+		Panic  string       `json:"panic"`
+		Stack  []StackFrame `json:"stack"`
+	}
+	assertNoError(t, json.Unmarshal([]byte(lines[1]), &panicEntry))
+	assertEqual(t, 500, panicEntry.Status)
+	assertEqual(t, "oops", panicEntry.Panic)
+	if len(panicEntry.Stack) == 0 || !strings.HasSuffix(panicEntry.Stack[0].Func, "TestJSONLogFormatter.func2") ||
+		!strings.HasSuffix(panicEntry.Stack[0].File, "logger_json_test.go") || panicEntry.Stack[0].Line == 0 {
+		t.Fatalf("unexpected stack %+v", panicEntry.Stack)
+	}
+}
+
+func TestJSONLogFormatterFields(t *testing.T) {
+	var buf bytes.Buffer
+	f := &JSONLogFormatter{Writer: &buf, Fields: []string{LogFieldMethod, LogFieldStatus}}
+
+	handler := RequestLogger(f)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	assertEqual(t, `{"method":"POST","status":418}`+"\n", buf.String())
+}
+
+func TestJSONLogFormatterImplicitStatus(t *testing.T) {
+	var buf bytes.Buffer
+	f := &JSONLogFormatter{Writer: &buf, Fields: []string{LogFieldStatus, LogFieldLevel}}
+
+	handler := RequestLogger(f)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, `{"level":"info","status":200}`+"\n", buf.String())
+}
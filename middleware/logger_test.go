@@ -3,11 +3,15 @@ package middleware
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type testLoggerWriter struct {
@@ -49,3 +53,77 @@ func TestRequestLoggerReadFrom(t *testing.T) {
 
 	assertEqual(t, data, w.Body.Bytes())
 }
+
+type testLogEntries struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (f *testLogEntries) NewLogEntry(r *http.Request) LogEntry {
+	return &testLogEntry{f: f, path: r.URL.Path}
+}
+
+type testLogEntry struct {
+	f    *testLogEntries
+	path string
+}
+
+func (e *testLogEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
+	entry := fmt.Sprintf("%s %d", e.path, status)
+	if details, ok := extra.(*LogDetails); ok {
+		entry += " " + details.Level.String()
+		if details.Slow {
+			entry += " slow"
+		}
+	} else if extra != nil {
+		entry += fmt.Sprintf(" %v", extra)
+	}
+	e.f.mu.Lock()
+	e.f.entries = append(e.f.entries, entry)
+	e.f.mu.Unlock()
+}
+
+func (e *testLogEntry) Panic(v interface{}, stack []byte) {}
+
+func TestRequestLoggerWithOpts(t *testing.T) {
+	f := &testLogEntries{}
+
+	r := chi.NewRouter()
+	r.Use(RequestLoggerWithOpts(f, RequestLoggerOpts{
+		SkipPaths:     []string{"/ping"},
+		SkipRoutes:    []string{"/metrics/{name}"},
+		SampleRate:    0.000001,
+		SlowThreshold: 20 * time.Millisecond,
+	}))
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/metrics/{name}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	r.Get("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	for _, path := range []string{"/ping", "/metrics/go", "/ok", "/ok", "/nope", "/slow", "/fail"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assertEqual(t, []string{"/nope 404 warn", "/slow 200 info slow", "/fail 503 error"}, f.entries)
+}
+
+func TestRequestLoggerWithoutDetails(t *testing.T) {
+	f := &testLogEntries{}
+
+	r := chi.NewRouter()
+	r.Use(RequestLoggerWithOpts(f, RequestLoggerOpts{SkipPaths: []string{"/ping"}}))
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, path := range []string{"/ping", "/ok", "/nope"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assertEqual(t, []string{"/ok 0", "/nope 404"}, f.entries)
+}
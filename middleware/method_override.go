@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	// MethodOverrideCtxKey is the context.Context key to store the wire
+	// method of requests overridden by the MethodOverride middleware.
+	MethodOverrideCtxKey = &contextKey{"MethodOverride"}
+)
+
+// MethodOverrideOpts represents a set of method override options.
+type MethodOverrideOpts struct {
+	// Header is the request header carrying the override method. Defaults
+	// to "X-HTTP-Method-Override".
+	Header string
+
+	// FormField is the form field carrying the override method, checked when
+	// the header is absent. Defaults to "_method".
+	FormField string
+
+	// Methods is the allow-list of methods a POST may be overridden with.
+	// Defaults to PUT, PATCH and DELETE.
+	Methods []string
+}
+
+// MethodOverride is a middleware that routes POST requests as though they
+// were sent with the method given in the X-HTTP-Method-Override header or
+// the `_method` form field. It's useful for HTML forms and legacy proxies
+// that can only send GET and POST.
+//
+// The overridden method is set as the routing method on the chi routing
+// context, similarly to GetHead, and as r.Method of the request passed to the
+// handlers. See GetMethodOverride to log both the wire and the overridden
+// methods. Only PUT, PATCH and DELETE are allowed as overrides.
+func MethodOverride(next http.Handler) http.Handler {
+	return MethodOverrideWithOpts(MethodOverrideOpts{})(next)
+}
+
+// MethodOverrideWithOpts is a middleware that overrides the routing method of
+// POST requests using passed MethodOverrideOpts.
+func MethodOverrideWithOpts(opts MethodOverrideOpts) func(next http.Handler) http.Handler {
+	header := opts.Header
+	if header == "" {
+		header = "X-HTTP-Method-Override"
+	}
+	field := opts.FormField
+	if field == "" {
+		field = "_method"
+	}
+	methods := opts.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	allowed := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		allowed[strings.ToUpper(m)] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			rctx := chi.RouteContext(r.Context())
+			if r.Method != http.MethodPost || rctx == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			m := r.Header.Get(header)
+			if m == "" && isFormContentType(r.Header.Get("Content-Type")) {
+				m = r.PostFormValue(field)
+			}
+			m = strings.ToUpper(strings.TrimSpace(m))
+
+			if _, ok := allowed[m]; ok {
+				rctx.RouteMethod = m
+				r = r.WithContext(context.WithValue(r.Context(), MethodOverrideCtxKey, r.Method))
+				r.Method = m
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetMethodOverride returns the method a POST request was overridden with by
+// the MethodOverride middleware, or an empty string. It accepts the request
+// as seen by the handlers as well as the one seen by the middlewares before
+// MethodOverride, such as the one of a LogEntry.
+func GetMethodOverride(r *http.Request) string {
+	if _, ok := r.Context().Value(MethodOverrideCtxKey).(string); ok {
+		return r.Method
+	}
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || r.Method != http.MethodPost || rctx.RouteMethod == "" || rctx.RouteMethod == r.Method {
+		return ""
+	}
+	return rctx.RouteMethod
+}
+
+func isFormContentType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/x-www-form-urlencoded" || mt == "multipart/form-data"
+}
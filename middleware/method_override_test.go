@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMethodOverride(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(MethodOverride)
+	r.Post("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("post"))
+	})
+	r.Put("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("put:" + r.Method))
+	})
+	r.Delete("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("delete:" + r.Method))
+	})
+	r.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("get"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "POST", "/articles/1", nil); body != "post" {
+		t.Fatalf(body)
+	}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/articles/1", nil)
+	req.Header.Set("X-HTTP-Method-Override", "put")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	buf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || buf.String() != "put:PUT" {
+		t.Fatalf("expecting 200 'put:PUT' but got %d '%s'", resp.StatusCode, buf.String())
+	}
+
+	form := url.Values{"_method": {"DELETE"}}
+	resp, err = http.Post(ts.URL+"/articles/1", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	buf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if buf.String() != "delete:DELETE" {
+		t.Fatalf("expecting 'delete:DELETE' but got '%s'", buf.String())
+	}
+
+	// Methods outside of the allow-list are ignored.
+	form = url.Values{"_method": {"GET"}}
+	resp, err = http.Post(ts.URL+"/articles/1", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	buf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if buf.String() != "post" {
+		t.Fatalf("expecting 'post' but got '%s'", buf.String())
+	}
+
+	// Only POST requests may be overridden.
+	req, _ = http.NewRequest("GET", ts.URL+"/articles/1", nil)
+	req.Header.Set("X-HTTP-Method-Override", "DELETE")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	buf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if buf.String() != "get" {
+		t.Fatalf("expecting 'get' but got '%s'", buf.String())
+	}
+}
+
+func TestMethodOverrideLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	r := chi.NewRouter()
+	r.Use(RequestLogger(&DefaultLogFormatter{Logger: log.New(out, "", 0), NoColor: true}))
+	r.Use(MethodOverride)
+	r.Delete("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("X-HTTP-Method-Override", "DELETE")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(out.String(), `"POST (DELETE) http://`) {
+		t.Fatalf("expecting wire and effective method in log, got: %s", out.String())
+	}
+}
+
+func TestMethodOverrideJSONLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	r := chi.NewRouter()
+	r.Use(RequestLogger(&JSONLogFormatter{Writer: out, Fields: []string{LogFieldMethod}}))
+	r.Use(MethodOverride)
+	r.Put("/", func(w http.ResponseWriter, r *http.Request) {
+		assertEqual(t, "PUT", GetMethodOverride(r))
+	})
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("X-HTTP-Method-Override", "PUT")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	want := `{"method":"POST","method_override":"PUT"}` + "\n" + `{"method":"POST"}` + "\n"
+	assertEqual(t, want, out.String())
+}
+
+func TestGetHeadLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	r := chi.NewRouter()
+	r.Use(RequestLogger(&DefaultLogFormatter{Logger: log.New(out, "", 0), NoColor: true}))
+	r.Use(GetHead)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/", nil))
+
+	if !strings.Contains(out.String(), `"HEAD http://`) {
+		t.Fatalf("expecting the HEAD method alone in log, got: %s", out.String())
+	}
+}
@@ -0,0 +1,332 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	// DefaultLatencyBuckets are the default buckets, in seconds, of the
+	// request duration histogram.
+	DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	// DefaultSizeBuckets are the default buckets, in bytes, of the response
+	// size histogram.
+	DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1e6, 1e7, 1e8}
+)
+
+// MetricsOpts represents a set of metrics options.
+type MetricsOpts struct {
+	// Namespace prefixes the metric names. Defaults to "http".
+	Namespace string
+
+	// LatencyBuckets are the upper bounds, in seconds, of the buckets of the
+	// request duration histogram. Defaults to DefaultLatencyBuckets.
+	LatencyBuckets []float64
+
+	// SizeBuckets are the upper bounds, in bytes, of the buckets of the
+	// response size histogram. Defaults to DefaultSizeBuckets.
+	SizeBuckets []float64
+}
+
+// Metrics collects request metrics, and exposes them in the Prometheus text
+// format. Metrics are labeled by method, status class (e.g., "2xx") and route
+// pattern, which keeps their cardinality bounded. Requests of non-standard
+// methods are labeled with the "OTHER" method.
+//
+//  metrics := middleware.NewMetrics(middleware.MetricsOpts{})
+//
+//  r := chi.NewRouter()
+//  r.Use(metrics.Handler)
+//  r.Mount("/debug", middleware.Profiler())
+//  r.Handle("/metrics", metrics)
+type Metrics struct {
+	namespace      string
+	latencyBuckets []float64
+	sizeBuckets    []float64
+
+	inFlight int64
+
+	mu        sync.Mutex
+	requests  map[requestLabels]*requestMetrics
+	throttles map[string]*throttleStats
+}
+
+type requestLabels struct {
+	method, status, route string
+}
+
+type requestMetrics struct {
+	count    uint64
+	duration *histogram
+	size     *histogram
+}
+
+// NewMetrics creates a new Metrics.
+func NewMetrics(opts MetricsOpts) *Metrics {
+	if opts.Namespace == "" {
+		opts.Namespace = "http"
+	}
+	if len(opts.LatencyBuckets) == 0 {
+		opts.LatencyBuckets = DefaultLatencyBuckets
+	}
+	if len(opts.SizeBuckets) == 0 {
+		opts.SizeBuckets = DefaultSizeBuckets
+	}
+	return &Metrics{
+		namespace:      opts.Namespace,
+		latencyBuckets: sortedBuckets(opts.LatencyBuckets),
+		sizeBuckets:    sortedBuckets(opts.SizeBuckets),
+		requests:       make(map[requestLabels]*requestMetrics),
+		throttles:      make(map[string]*throttleStats),
+	}
+}
+
+// Handler returns a new middleware that records the metrics of requests.
+// Requests are labeled with the route pattern they matched, as read after
+// the handler returns, so use it with r.Use on the top router to label routes
+// of mounted sub-routers too.
+func (m *Metrics) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&m.inFlight, 1)
+		defer atomic.AddInt64(&m.inFlight, -1)
+
+		ww := NewWrapResponseWriter(w, r.ProtoMajor)
+		t1 := time.Now()
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if rvr := recover(); rvr != nil {
+				status = http.StatusInternalServerError
+				defer panic(rvr)
+			}
+
+			route := ""
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			m.observe(r.Method, status, route, time.Since(t1), ww.BytesWritten())
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+func (m *Metrics) observe(method string, status int, route string, elapsed time.Duration, size int) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+	default:
+		method = "OTHER"
+	}
+	labels := requestLabels{method: method, status: strconv.Itoa(status/100) + "xx", route: route}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rm, ok := m.requests[labels]
+	if !ok {
+		rm = &requestMetrics{
+			duration: newHistogram(m.latencyBuckets),
+			size:     newHistogram(m.sizeBuckets),
+		}
+		m.requests[labels] = rm
+	}
+	rm.count++
+	rm.duration.observe(elapsed.Seconds())
+	rm.size.observe(float64(size))
+}
+
+// registerThrottle exposes the statistics of a throttler.
+func (m *Metrics) registerThrottle(name string, stats *throttleStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.throttles[name]; ok {
+		panic(fmt.Sprintf("chi/middleware: Metrics already has a throttle named '%s'", name))
+	}
+	stats.mu.Lock()
+	stats.wait = newHistogram(m.latencyBuckets)
+	stats.mu.Unlock()
+	m.throttles[name] = stats
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ns := m.namespace
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	writeMetricHeader(bw, ns+"_requests_total", "counter", "Total number of HTTP requests.")
+	for _, l := range labels {
+		fmt.Fprintf(bw, "%s_requests_total%s %d\n", ns, l.String(""), m.requests[l].count)
+	}
+
+	writeMetricHeader(bw, ns+"_requests_in_flight", "gauge", "Number of HTTP requests being served.")
+	fmt.Fprintf(bw, "%s_requests_in_flight %d\n", ns, atomic.LoadInt64(&m.inFlight))
+
+	writeMetricHeader(bw, ns+"_request_duration_seconds", "histogram", "Duration of HTTP requests in seconds.")
+	for _, l := range labels {
+		m.requests[l].duration.write(bw, ns+"_request_duration_seconds", l)
+	}
+
+	writeMetricHeader(bw, ns+"_response_size_bytes", "histogram", "Size of HTTP responses in bytes.")
+	for _, l := range labels {
+		m.requests[l].size.write(bw, ns+"_response_size_bytes", l)
+	}
+
+	if len(m.throttles) == 0 {
+		return
+	}
+	names := make([]string, 0, len(m.throttles))
+	for name := range m.throttles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeMetricHeader(bw, ns+"_throttle_limit", "gauge", "Concurrency limit of a throttle.")
+	for _, name := range names {
+		fmt.Fprintf(bw, "%s_throttle_limit{throttle=\"%s\"} %d\n", ns, escapeLabel(name), atomic.LoadInt64(&m.throttles[name].limit))
+	}
+	writeMetricHeader(bw, ns+"_throttle_in_flight", "gauge", "Number of requests being processed by a throttle.")
+	for _, name := range names {
+		fmt.Fprintf(bw, "%s_throttle_in_flight{throttle=\"%s\"} %d\n", ns, escapeLabel(name), atomic.LoadInt64(&m.throttles[name].processing))
+	}
+	writeMetricHeader(bw, ns+"_throttle_backlog", "gauge", "Number of requests waiting in the backlog of a throttle.")
+	for _, name := range names {
+		fmt.Fprintf(bw, "%s_throttle_backlog{throttle=\"%s\"} %d\n", ns, escapeLabel(name), atomic.LoadInt64(&m.throttles[name].waiting))
+	}
+	writeMetricHeader(bw, ns+"_throttle_rejected_total", "counter", "Total number of requests rejected by a throttle.")
+	for _, name := range names {
+		stats := m.throttles[name]
+		for _, reason := range []struct {
+			name  string
+			count *int64
+		}{
+			{"capacity", &stats.rejectedCapacity},
+			{"timeout", &stats.rejectedTimeout},
+			{"canceled", &stats.rejectedCanceled},
+			{"deadline", &stats.rejectedDeadline},
+		} {
+			fmt.Fprintf(bw, "%s_throttle_rejected_total{throttle=\"%s\",reason=\"%s\"} %d\n", ns, escapeLabel(name), reason.name, atomic.LoadInt64(reason.count))
+		}
+	}
+	writeMetricHeader(bw, ns+"_throttle_wait_seconds", "histogram", "Time requests waited for a throttle token in seconds.")
+	for _, name := range names {
+		stats := m.throttles[name]
+		stats.mu.Lock()
+		stats.wait.write(bw, ns+"_throttle_wait_seconds", throttleLabels(name))
+		stats.mu.Unlock()
+	}
+}
+
+// metricLabels formats the labels of a metric, with an extra "le" label for
+// histogram buckets.
+type metricLabels interface {
+	String(le string) string
+}
+
+// String formats the labels, with an extra "le" label for histogram buckets.
+func (l requestLabels) String(le string) string {
+	s := fmt.Sprintf(`{method="%s",route="%s",status="%s"`, escapeLabel(l.method), escapeLabel(l.route), l.status)
+	if le != "" {
+		s += `,le="` + le + `"`
+	}
+	return s + "}"
+}
+
+type throttleLabels string
+
+// String formats the labels, with an extra "le" label for histogram buckets.
+func (l throttleLabels) String(le string) string {
+	s := `{throttle="` + escapeLabel(string(l)) + `"`
+	if le != "" {
+		s += `,le="` + le + `"`
+	}
+	return s + "}"
+}
+
+func writeMetricHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return b
+}
+
+// histogram counts observations in buckets, see
+// https://prometheus.io/docs/concepts/metric_types/#histogram.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w *bufio.Writer, name string, l metricLabels) {
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, l.String(formatFloat(le)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, l.String("+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, l.String(""), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, l.String(""), h.count)
+}
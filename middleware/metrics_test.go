@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics(MetricsOpts{LatencyBuckets: []float64{1, 0.1}, SizeBuckets: []float64{10, 100}})
+
+	r := chi.NewRouter()
+	r.Use(metrics.Handler)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + chi.URLParam(r, "id")))
+	})
+	r.Post("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("x", 50)))
+	})
+	r.Handle("/metrics", metrics)
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/3"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/users", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/users", nil))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, line := range []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{method="GET",route="/users/{id}",status="2xx"} 3`,
+		`http_requests_total{method="POST",route="/users",status="4xx"} 1`,
+		`http_requests_total{method="OTHER",route="",status="5xx"} 1`,
+		"http_requests_in_flight 1",
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="2xx",le="0.1"} 3`,
+		`http_request_duration_seconds_count{method="GET",route="/users/{id}",status="2xx"} 3`,
+		`http_response_size_bytes_bucket{method="GET",route="/users/{id}",status="2xx",le="10"} 3`,
+		`http_response_size_bytes_bucket{method="POST",route="/users",status="4xx",le="10"} 0`,
+		`http_response_size_bytes_bucket{method="POST",route="/users",status="4xx",le="100"} 1`,
+		`http_response_size_bytes_bucket{method="POST",route="/users",status="4xx",le="+Inf"} 1`,
+		`http_response_size_bytes_sum{method="POST",route="/users",status="4xx"} 50`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expecting metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsThrottle(t *testing.T) {
+	metrics := NewMetrics(MetricsOpts{})
+	started, release := make(chan struct{}), make(chan struct{})
+
+	r := chi.NewRouter()
+	r.Use(ThrottleWithOpts(ThrottleOpts{Limit: 1, BacklogTimeout: time.Second, Metrics: metrics, Name: "api"}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	done := make(chan struct{})
+	go func() {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assertEqual(t, http.StatusTooManyRequests, w.Code)
+
+	w = httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	close(release)
+	<-done
+
+	for _, line := range []string{
+		`http_throttle_limit{throttle="api"} 1`,
+		`http_throttle_in_flight{throttle="api"} 1`,
+		`http_throttle_backlog{throttle="api"} 0`,
+		`http_throttle_rejected_total{throttle="api",reason="capacity"} 1`,
+		`http_throttle_rejected_total{throttle="api",reason="timeout"} 0`,
+		`http_throttle_wait_seconds_count{throttle="api"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("expecting metrics to contain %q, got:\n%s", line, w.Body.String())
+		}
+	}
+}
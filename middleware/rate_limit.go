@@ -0,0 +1,331 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RateLimitAlgorithm selects how requests are counted against a rate limit.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket refills a bucket of Burst tokens at a rate of Limit tokens
+	// per Window, and each request takes a token. It allows short bursts
+	// while keeping the average rate.
+	TokenBucket RateLimitAlgorithm = iota
+
+	// SlidingWindow counts the requests of the current window, weighted with
+	// those of the previous window, and allows up to Limit per Window.
+	SlidingWindow
+)
+
+// RateLimit describes a rate limit of Limit requests per Window.
+type RateLimit struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Window    time.Duration
+
+	// Burst is the capacity of the token bucket. Defaults to Limit.
+	Burst int
+}
+
+// RateLimitResult is the outcome of counting a request against a rate limit.
+type RateLimitResult struct {
+	// Allowed reports whether the request is within the limit.
+	Allowed bool
+
+	// Limit is the request quota of the window.
+	Limit int
+
+	// Remaining is the number of requests left in the window.
+	Remaining int
+
+	// Reset is the time until the quota is fully restored.
+	Reset time.Duration
+
+	// RetryAfter is the time until the next request is allowed, when the
+	// request is not allowed.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the rate limiting state of each key. Implementations
+// must be safe for concurrent use. See NewRateLimitMemoryStore.
+type RateLimitStore interface {
+	// Take counts a request for the key against the rate limit.
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitOpts represents a set of rate limiting options.
+type RateLimitOpts struct {
+	RateLimit
+
+	// KeyFunc returns the key requests are counted under, such as the client IP.
+	// Defaults to KeyByIP.
+	KeyFunc func(r *http.Request) string
+
+	// Store keeps the state of the rate limits. Defaults to a new in-memory
+	// store for the middleware.
+	Store RateLimitStore
+
+	// LimitedHandler responds to requests over the limit. Defaults to a
+	// 429 Too Many Requests response.
+	LimitedHandler http.Handler
+}
+
+// RateLimitByIP is a middleware that limits each client IP to `limit`
+// requests per `window`, using a token bucket. See RateLimitWithOpts.
+func RateLimitByIP(limit int, window time.Duration) func(next http.Handler) http.Handler {
+	return RateLimitWithOpts(RateLimitOpts{RateLimit: RateLimit{Limit: limit, Window: window}})
+}
+
+// RateLimitWithOpts is a middleware that limits the rate of requests per key
+// using passed RateLimitOpts. Unlike Throttle, which caps the number of
+// requests processed at a time, it limits the number of requests of each
+// client over time.
+//
+// Responses carry the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, and requests over the limit are answered with a Retry-After header.
+// Requests are allowed through when the store fails.
+//
+// Limits can be set per route with inline middlewares, in which case the
+// route pattern is available to the KeyFunc:
+//
+//  r.Use(middleware.RealIP)
+//  r.With(middleware.RateLimitWithOpts(middleware.RateLimitOpts{
+//    RateLimit: middleware.RateLimit{Algorithm: middleware.SlidingWindow, Limit: 10, Window: time.Minute},
+//    KeyFunc:   middleware.RateLimitKeys(middleware.KeyByRoutePattern, middleware.KeyByIP),
+//  })).Post("/login", login)
+func RateLimitWithOpts(opts RateLimitOpts) func(next http.Handler) http.Handler {
+	if opts.Limit < 1 {
+		panic("chi/middleware: RateLimit expects limit > 0")
+	}
+	if opts.Window <= 0 {
+		panic("chi/middleware: RateLimit expects window > 0")
+	}
+	if opts.Burst < 1 {
+		opts.Burst = opts.Limit
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = KeyByIP
+	}
+	if opts.Store == nil {
+		opts.Store = NewRateLimitMemoryStore(0)
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			res, err := opts.Store.Take(opts.KeyFunc(r), opts.RateLimit)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				if opts.LimitedHandler != nil {
+					opts.LimitedHandler.ServeHTTP(w, r)
+				} else {
+					http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// KeyByIP keys requests by the client IP in r.RemoteAddr. Use it after the
+// RealIP middleware when running behind a reverse proxy.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader keys requests by the value of a request header, like an API key.
+// Requests without the header share a single key.
+func KeyByHeader(header string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// KeyByRoutePattern keys requests by the routing pattern matched so far. Use
+// it in an inline middleware to get the full route pattern of the endpoint.
+func KeyByRoutePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
+
+// RateLimitKeys combines several key functions into one.
+func RateLimitKeys(fns ...func(r *http.Request) string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		key := ""
+		for i, fn := range fns {
+			if i > 0 {
+				key += "\x00"
+			}
+			key += fn(r)
+		}
+		return key
+	}
+}
+
+const defaultRateLimitShards = 32
+
+// NewRateLimitMemoryStore returns an in-memory RateLimitStore, sharded to
+// reduce lock contention. Expired state is evicted as the store is used.
+// A shards value below 1 uses a default of 32 shards.
+func NewRateLimitMemoryStore(shards int) RateLimitStore {
+	if shards < 1 {
+		shards = defaultRateLimitShards
+	}
+	s := &rateLimitMemoryStore{shards: make([]rateLimitShard, shards), now: time.Now}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateLimitEntry)
+	}
+	return s
+}
+
+type rateLimitMemoryStore struct {
+	shards []rateLimitShard
+	now    func() time.Time
+}
+
+type rateLimitShard struct {
+	mu      sync.Mutex
+	entries map[string]*rateLimitEntry
+	ops     int
+}
+
+// rateLimitEntry is the state of a key, for either algorithm.
+type rateLimitEntry struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	// sliding window
+	windowStart time.Time
+	prev, curr  int
+
+	expires time.Time
+}
+
+func (s *rateLimitMemoryStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%uint32(len(s.shards))]
+
+	now := s.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.ops++
+	if shard.ops%1024 == 0 {
+		for k, e := range shard.entries {
+			if now.After(e.expires) {
+				delete(shard.entries, k)
+			}
+		}
+	}
+
+	e, ok := shard.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &rateLimitEntry{tokens: float64(limit.burst()), last: now, windowStart: now}
+		shard.entries[key] = e
+	}
+
+	if limit.Algorithm == SlidingWindow {
+		return e.takeSlidingWindow(limit, now), nil
+	}
+	return e.takeTokenBucket(limit, now), nil
+}
+
+func (e *rateLimitEntry) takeTokenBucket(limit RateLimit, now time.Time) RateLimitResult {
+	burst := float64(limit.burst())
+	perToken := limit.Window / time.Duration(limit.Limit)
+
+	e.tokens = math.Min(burst, e.tokens+float64(now.Sub(e.last))/float64(perToken))
+	e.last = now
+
+	res := RateLimitResult{Limit: limit.burst()}
+	if e.tokens >= 1 {
+		e.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - e.tokens) * float64(perToken))
+	}
+	res.Remaining = int(e.tokens)
+	res.Reset = time.Duration((burst - e.tokens) * float64(perToken))
+	e.expires = now.Add(res.Reset)
+	return res
+}
+
+func (e *rateLimitEntry) takeSlidingWindow(limit RateLimit, now time.Time) RateLimitResult {
+	// Roll the windows forward
+	if elapsed := now.Sub(e.windowStart); elapsed >= limit.Window {
+		n := int(elapsed / limit.Window)
+		if n == 1 {
+			e.prev = e.curr
+		} else {
+			e.prev = 0
+		}
+		e.curr = 0
+		e.windowStart = e.windowStart.Add(time.Duration(n) * limit.Window)
+	}
+
+	elapsed := now.Sub(e.windowStart)
+	weight := 1 - float64(elapsed)/float64(limit.Window)
+	count := float64(e.prev)*weight + float64(e.curr)
+
+	res := RateLimitResult{Limit: limit.Limit, Reset: limit.Window - elapsed}
+	if count+1 <= float64(limit.Limit) {
+		e.curr++
+		count++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = res.Reset
+		if e.curr < limit.Limit && e.prev > 0 {
+			// Wait until enough of the previous window has slid out.
+			need := 1 - float64(limit.Limit-1-e.curr)/float64(e.prev)
+			if wait := time.Duration(need*float64(limit.Window)) - elapsed; wait > 0 && wait < res.RetryAfter {
+				res.RetryAfter = wait
+			}
+		}
+	}
+	res.Remaining = limit.Limit - int(math.Ceil(count))
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	e.expires = e.windowStart.Add(2 * limit.Window)
+	return res
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst < 1 {
+		return l.Limit
+	}
+	return l.Burst
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
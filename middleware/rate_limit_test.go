@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewRateLimitMemoryStore(4).(*rateLimitMemoryStore)
+	store.now = func() time.Time { return now }
+
+	r := chi.NewRouter()
+	r.Use(RateLimitWithOpts(RateLimitOpts{
+		RateLimit: RateLimit{Limit: 2, Window: time.Second},
+		Store:     store,
+	}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := do("10.0.0.1:1234"); w.Code != 200 {
+			t.Fatalf("request %d: expecting 200 but got %d", i, w.Code)
+		}
+	}
+	w := do("10.0.0.1:5678")
+	if w.Code != 429 {
+		t.Fatalf("expecting 429 but got %d", w.Code)
+	}
+	assertEqual(t, "2", w.Header().Get("RateLimit-Limit"))
+	assertEqual(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assertEqual(t, "1", w.Header().Get("Retry-After"))
+
+	// Other clients have their own bucket.
+	if w := do("10.0.0.2:1234"); w.Code != 200 {
+		t.Fatalf("expecting 200 but got %d", w.Code)
+	}
+
+	// Half a window refills a token.
+	now = now.Add(500 * time.Millisecond)
+	if w := do("10.0.0.1:1234"); w.Code != 200 {
+		t.Fatalf("expecting 200 after refill but got %d", w.Code)
+	}
+	if w := do("10.0.0.1:1234"); w.Code != 429 {
+		t.Fatalf("expecting 429 but got %d", w.Code)
+	}
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewRateLimitMemoryStore(0).(*rateLimitMemoryStore)
+	store.now = func() time.Time { return now }
+
+	limit := RateLimit{Algorithm: SlidingWindow, Limit: 4, Window: time.Minute}
+
+	for i := 0; i < 4; i++ {
+		res, _ := store.Take("k", limit)
+		if !res.Allowed || res.Remaining != 3-i {
+			t.Fatalf("request %d: unexpected result %+v", i, res)
+		}
+	}
+	res, _ := store.Take("k", limit)
+	if res.Allowed || res.RetryAfter != time.Minute {
+		t.Fatalf("expecting request to be limited until the window ends, got %+v", res)
+	}
+
+	// Half way through the next window, half of the previous one still counts.
+	now = now.Add(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if res, _ := store.Take("k", limit); !res.Allowed {
+			t.Fatalf("request %d: expecting request to be allowed, got %+v", i, res)
+		}
+	}
+	res, _ = store.Take("k", limit)
+	if res.Allowed || res.RetryAfter != 15*time.Second {
+		t.Fatalf("expecting request to be limited for 15s, got %+v", res)
+	}
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+	r := chi.NewRouter()
+	r.With(RateLimitWithOpts(RateLimitOpts{
+		RateLimit: RateLimit{Limit: 1, Window: time.Hour},
+		KeyFunc:   RateLimitKeys(KeyByRoutePattern, KeyByHeader("X-API-Key")),
+	})).Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	do := func(path, apiKey string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assertEqual(t, 200, do("/items/1", "a"))
+	assertEqual(t, 429, do("/items/2", "a")) // same route pattern
+	assertEqual(t, 200, do("/items/1", "b"))
+}
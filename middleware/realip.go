@@ -4,6 +4,9 @@ package middleware
 // https://github.com/zenazn/goji/tree/master/web/middleware
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -25,7 +28,8 @@ var xRealIP = http.CanonicalHeaderKey("X-Real-IP")
 // chi. If your reverse proxies are configured to pass along arbitrary header
 // values from the client, or if you use this middleware without a reverse
 // proxy, malicious clients will be able to make you very sad (or, depending on
-// how you're using RemoteAddr, vulnerable to an attack of some sort).
+// how you're using RemoteAddr, vulnerable to an attack of some sort). See
+// RealIPWithOpts to only trust the headers set by your own proxies.
 func RealIP(h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		if rip := realIP(r); rip != "" {
@@ -52,3 +56,259 @@ func realIP(r *http.Request) string {
 
 	return ip
 }
+
+var (
+	// ClientInfoCtxKey is the context.Context key to store the ClientInfo
+	// resolved by the RealIPWithOpts middleware.
+	ClientInfoCtxKey = &contextKey{"ClientInfo"}
+)
+
+// ClientInfo describes the client connection of a request that went through
+// reverse proxies.
+type ClientInfo struct {
+	// IP is the client IP.
+	IP string
+
+	// Scheme is the scheme, "http" or "https", the client used.
+	Scheme string
+
+	// Host is the host the client requested.
+	Host string
+}
+
+// GetClientInfo returns the ClientInfo resolved by the RealIPWithOpts
+// middleware.
+func GetClientInfo(ctx context.Context) ClientInfo {
+	if info, ok := ctx.Value(ClientInfoCtxKey).(ClientInfo); ok {
+		return info
+	}
+	return ClientInfo{}
+}
+
+// RealIPOpts represents a set of RealIP options.
+type RealIPOpts struct {
+	// TrustedProxies are the IPs or CIDRs (e.g., "10.0.0.0/8") of the reverse
+	// proxies whose headers are trusted.
+	TrustedProxies []string
+
+	// Headers are the headers the client IP is read from, in order. Besides
+	// Forwarded and X-Forwarded-For, headers hold a single IP, like X-Real-IP,
+	// True-Client-IP or CF-Connecting-IP. Defaults to Forwarded,
+	// X-Forwarded-For and X-Real-IP.
+	Headers []string
+}
+
+// RealIPWithOpts is a middleware that resolves the client IP, scheme and host
+// of requests from the headers of trusted reverse proxies, using passed
+// RealIPOpts. It sets the RemoteAddr of requests to the client IP, and stores
+// the ClientInfo in the request context, see GetClientInfo.
+//
+// Headers are only read from requests of trusted proxies. The Forwarded
+// (RFC 7239) and X-Forwarded-For headers are walked from right to left,
+// skipping the hops of trusted proxies, so the client IP is the one seen by
+// the first trusted proxy and can't be spoofed by the client. The scheme and
+// host are taken from the X-Forwarded-Proto and X-Forwarded-Host entries of
+// that same hop, and are ignored when their count doesn't match the one of
+// X-Forwarded-For.
+//
+//  r.Use(middleware.RealIPWithOpts(middleware.RealIPOpts{
+//    TrustedProxies: []string{"10.0.0.0/8"},
+//    Headers:        []string{"CF-Connecting-IP", "X-Forwarded-For"},
+//  }))
+func RealIPWithOpts(opts RealIPOpts) func(next http.Handler) http.Handler {
+	var trusted []*net.IPNet
+	for _, p := range opts.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			panic(fmt.Sprintf("chi/middleware: invalid trusted proxy '%s'", p))
+		}
+		trusted = append(trusted, ipnet)
+	}
+	headers := opts.Headers
+	if len(headers) == 0 {
+		headers = []string{"Forwarded", xForwardedFor, xRealIP}
+	}
+
+	isTrusted := func(ip net.IP) bool {
+		for _, ipnet := range trusted {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			info := ClientInfo{IP: KeyByIP(r), Scheme: "http", Host: r.Host}
+			if r.TLS != nil {
+				info.Scheme = "https"
+			}
+
+			if ip := net.ParseIP(info.IP); ip != nil && isTrusted(ip) {
+				for _, h := range headers {
+					if resolveClientInfo(r.Header, h, isTrusted, &info) {
+						break
+					}
+				}
+				r.RemoteAddr = info.IP
+			}
+
+			ctx := context.WithValue(r.Context(), ClientInfoCtxKey, info)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// resolveClientInfo reads the client info from a header of a trusted proxy,
+// and reports whether the header had a valid client IP.
+func resolveClientInfo(header http.Header, name string, isTrusted func(net.IP) bool, info *ClientInfo) bool {
+	values := header.Values(name)
+	if len(values) == 0 {
+		return false
+	}
+
+	switch http.CanonicalHeaderKey(name) {
+	case "Forwarded":
+		elems := parseForwarded(values)
+		i := rightmostUntrusted(len(elems), func(i int) net.IP { return parseForwardedFor(elems[i]["for"]) }, isTrusted)
+		if i < 0 {
+			return false
+		}
+		info.IP = parseForwardedFor(elems[i]["for"]).String()
+		if proto := strings.ToLower(elems[i]["proto"]); proto == "http" || proto == "https" {
+			info.Scheme = proto
+		}
+		if host := elems[i]["host"]; host != "" {
+			info.Host = host
+		}
+		return true
+
+	case xForwardedFor:
+		hops := splitForwardedList(values)
+		i := rightmostUntrusted(len(hops), func(i int) net.IP { return net.ParseIP(hops[i]) }, isTrusted)
+		if i < 0 {
+			return false
+		}
+		info.IP = net.ParseIP(hops[i]).String()
+
+		// The proto and host of the chosen hop are the ones at the same
+		// position, the others were set by the client or other proxies. They
+		// can't be told apart when the counts don't line up.
+		if protos := splitForwardedList(header.Values("X-Forwarded-Proto")); len(protos) == len(hops) {
+			if proto := strings.ToLower(protos[i]); proto == "http" || proto == "https" {
+				info.Scheme = proto
+			}
+		}
+		if hosts := splitForwardedList(header.Values("X-Forwarded-Host")); len(hosts) == len(hops) && hosts[i] != "" {
+			info.Host = hosts[i]
+		}
+		return true
+
+	default:
+		ip := net.ParseIP(strings.TrimSpace(values[0]))
+		if ip == nil {
+			return false
+		}
+		info.IP = ip.String()
+		return true
+	}
+}
+
+// splitForwardedList splits the comma-separated values of X-Forwarded-*
+// headers.
+func splitForwardedList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+	}
+	return list
+}
+
+// rightmostUntrusted walks the hops of a forwarding header from right to
+// left, and returns the index of the first hop that isn't a trusted proxy, or
+// of the leftmost hop when all of them are. It returns -1 when a hop isn't a
+// valid IP before an untrusted one is found.
+func rightmostUntrusted(n int, hop func(i int) net.IP, isTrusted func(net.IP) bool) int {
+	for i := n - 1; i >= 0; i-- {
+		ip := hop(i)
+		if ip == nil {
+			return -1
+		}
+		if !isTrusted(ip) || i == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseForwarded parses the elements of Forwarded headers, see RFC 7239,
+// section 4, into maps of lowercase parameter names to values.
+func parseForwarded(values []string) []map[string]string {
+	var elems []map[string]string
+	for _, v := range values {
+		for _, elem := range splitQuoted(v, ',') {
+			params := map[string]string{}
+			for _, pair := range splitQuoted(elem, ';') {
+				i := strings.IndexByte(pair, '=')
+				if i < 0 {
+					continue
+				}
+				name := strings.ToLower(strings.TrimSpace(pair[:i]))
+				value := strings.TrimSpace(pair[i+1:])
+				if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+					value = strings.Replace(value[1:len(value)-1], `\"`, `"`, -1)
+				}
+				params[name] = value
+			}
+			elems = append(elems, params)
+		}
+	}
+	return elems
+}
+
+// parseForwardedFor parses the node of a Forwarded "for" parameter, such
+// as "192.0.2.60", "192.0.2.60:4711" or "[2001:db8:cafe::17]:4711". Obfuscated
+// and unknown nodes are nil.
+func parseForwardedFor(node string) net.IP {
+	if strings.HasPrefix(node, "[") {
+		if i := strings.IndexByte(node, ']'); i > 0 {
+			return net.ParseIP(node[1:i])
+		}
+		return nil
+	}
+	if i := strings.IndexByte(node, ':'); i >= 0 && strings.Count(node, ":") == 1 {
+		node = node[:i]
+	}
+	return net.ParseIP(node)
+}
+
+// splitQuoted splits s by sep, except within quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && quoted:
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
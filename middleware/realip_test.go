@@ -80,3 +80,96 @@ func TestXForwardForXRealIPPrecedence(t *testing.T) {
 		t.Fatal("Test get real IP precedence error.")
 	}
 }
+
+func TestRealIPWithOpts(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(RealIPWithOpts(RealIPOpts{
+		TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"},
+		Headers:        []string{"CF-Connecting-IP", "Forwarded", "X-Forwarded-For"},
+	}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		info := GetClientInfo(r.Context())
+		w.Write([]byte(r.RemoteAddr + " " + info.IP + " " + info.Scheme + " " + info.Host))
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "1.2.3.4:1234",
+			header:     http.Header{"X-Forwarded-For": {"5.5.5.5"}},
+			want:       "1.2.3.4:1234 1.2.3.4 http example.com",
+		},
+		{
+			name:       "x-forwarded-for skips trusted hops",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"6.6.6.6, 5.5.5.5", "10.1.1.1"},
+				"X-Forwarded-Proto": {"http, https", "http"},
+				"X-Forwarded-Host":  {"evil.example.com, api.example.com", "internal"},
+			},
+			want: "5.5.5.5 5.5.5.5 https api.example.com",
+		},
+		{
+			name:       "x-forwarded-proto and host spoofed by the client",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"5.5.5.5"},
+				"X-Forwarded-Proto": {"https, http"},
+				"X-Forwarded-Host":  {"evil.example.com, api.example.com"},
+			},
+			want: "5.5.5.5 5.5.5.5 http example.com",
+		},
+		{
+			name:       "x-forwarded-for of trusted hops only",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"}},
+			want:       "10.2.2.2 10.2.2.2 http example.com",
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "[2001:db8::1]:1234",
+			header: http.Header{
+				"Forwarded":       {`for=6.6.6.6, for="[2001:db8:cafe::17]:4711";proto=https;host="shop.example.com"`, "for=10.1.1.1"},
+				"X-Forwarded-For": {"7.7.7.7"},
+			},
+			want: "2001:db8:cafe::17 2001:db8:cafe::17 https shop.example.com",
+		},
+		{
+			name:       "header order",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Cf-Connecting-Ip": {"8.8.8.8"},
+				"X-Forwarded-For":  {"5.5.5.5"},
+			},
+			want: "8.8.8.8 8.8.8.8 http example.com",
+		},
+		{
+			name:       "invalid header falls through",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Cf-Connecting-Ip": {"not an ip"},
+				"Forwarded":        {"for=unknown"},
+				"X-Forwarded-For":  {"5.5.5.5"},
+			},
+			want: "5.5.5.5 5.5.5.5 http example.com",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assertEqual(t, tc.want, w.Body.String())
+		})
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
 	"os"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -19,29 +22,98 @@ import (
 //
 // Alternatively, look at https://github.com/pressly/lg middleware pkgs.
 func Recoverer(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if rvr := recover(); rvr != nil && rvr != http.ErrAbortHandler {
+	return RecovererWithOpts(RecovererOpts{})(next)
+}
+
+// RecovererOpts represents a set of panic recovery options.
+type RecovererOpts struct {
+	// Renderer writes the response of a panic, unless the handler already
+	// started the response. Defaults to a bare 500 Internal Server Error.
+	Renderer func(w http.ResponseWriter, r *http.Request, rvr interface{})
+
+	// Reporter is called with each recovered panic and its stack frames, for
+	// example to report them to an error tracker.
+	Reporter func(r *http.Request, rvr interface{}, stack []StackFrame)
+
+	// Development renders panics as an HTML page with the stack and the
+	// request details, instead of using the Renderer. Never enable it in
+	// production, as it exposes the internals of the application.
+	Development bool
+
+	// RedactHeaders are the request headers whose values are hidden on the
+	// Development page. Defaults to DefaultRedactedHeaders.
+	RedactHeaders []string
+}
+
+// DefaultRedactedHeaders are the credential-bearing request headers hidden on
+// the Development page of RecovererWithOpts.
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// RecovererWithOpts is a middleware that recovers from panics using passed
+// RecovererOpts. Like Recoverer, it logs the panic and responds with a 500 if
+// the response wasn't committed yet by a Write or a Flush of the handler.
+//
+// Panics with http.ErrAbortHandler are passed through, for the http.Server to
+// abort the response.
+func RecovererWithOpts(opts RecovererOpts) func(next http.Handler) http.Handler {
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = DefaultRedactedHeaders
+	}
+	redact := make(map[string]bool, len(opts.RedactHeaders))
+	for _, name := range opts.RedactHeaders {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww := NewWrapResponseWriter(w, r.ProtoMajor)
 
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+				if rvr == http.ErrAbortHandler {
+					// we don't recover http.ErrAbortHandler so the response
+					// to the client is aborted, this should not be logged
+					panic(rvr)
+				}
+
+				debugStack := debug.Stack()
 				logEntry := GetLogEntry(r)
 				if logEntry != nil {
-					logEntry.Panic(rvr, debug.Stack())
+					logEntry.Panic(rvr, debugStack)
 				} else {
-					PrintPrettyStack(rvr)
+					printPrettyStack(debugStack, rvr)
+				}
+				if opts.Reporter != nil {
+					opts.Reporter(r, rvr, parseStack(debugStack))
 				}
 
-				w.WriteHeader(http.StatusInternalServerError)
-			}
-		}()
+				if ww.(interface{ committed() bool }).committed() {
+					return
+				}
+				switch {
+				case opts.Development:
+					renderPanicPage(ww, r, rvr, debugStack, redact)
+				case opts.Renderer != nil:
+					opts.Renderer(ww, r, rvr)
+				default:
+					ww.WriteHeader(http.StatusInternalServerError)
+				}
+			}()
 
-		next.ServeHTTP(w, r)
+			next.ServeHTTP(ww, r)
+		}
+		return http.HandlerFunc(fn)
 	}
-
-	return http.HandlerFunc(fn)
 }
 
 func PrintPrettyStack(rvr interface{}) {
-	debugStack := debug.Stack()
+	printPrettyStack(debug.Stack(), rvr)
+}
+
+func printPrettyStack(debugStack []byte, rvr interface{}) {
 	s := prettyStack{}
 	out, err := s.parse(debugStack, rvr)
 	if err == nil {
@@ -52,12 +124,84 @@ func PrintPrettyStack(rvr interface{}) {
 	}
 }
 
+var panicPageTmpl = template.Must(template.New("panic").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>panic: {{.Panic}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f6f6f6; padding: 1em; overflow: auto; }
+th { text-align: left; padding-right: 1em; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>panic: {{.Panic}}</h1>
+<pre>{{.Stack}}</pre>
+<h2>Request</h2>
+<table>
+<tr><th>Method</th><td>{{.Request.Method}}</td></tr>
+<tr><th>URL</th><td>{{.Request.RequestURI}}</td></tr>
+<tr><th>Protocol</th><td>{{.Request.Proto}}</td></tr>
+<tr><th>Host</th><td>{{.Request.Host}}</td></tr>
+<tr><th>Remote address</th><td>{{.Request.RemoteAddr}}</td></tr>
+{{- if .RequestID}}
+<tr><th>Request ID</th><td>{{.RequestID}}</td></tr>
+{{- end}}
+</table>
+<h2>Headers</h2>
+<table>
+{{- range .Headers}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// renderPanicPage renders the panic, its pretty stack and the request as an
+// HTML page, for development. The values of the redacted headers are hidden.
+func renderPanicPage(w http.ResponseWriter, r *http.Request, rvr interface{}, debugStack []byte, redact map[string]bool) {
+	stack, err := prettyStack{noColor: true}.parse(debugStack, rvr)
+	if err != nil {
+		stack = debugStack
+	}
+
+	type header struct{ Name, Value string }
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := make([]header, 0, len(names))
+	for _, name := range names {
+		for _, v := range r.Header[name] {
+			if redact[http.CanonicalHeaderKey(name)] {
+				v = "[redacted]"
+			}
+			headers = append(headers, header{name, v})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	panicPageTmpl.Execute(w, map[string]interface{}{
+		"Panic":     fmt.Sprint(rvr),
+		"Stack":     strings.TrimSpace(string(stack)),
+		"Request":   r,
+		"RequestID": GetReqID(r.Context()),
+		"Headers":   headers,
+	})
+}
+
 type prettyStack struct {
+	noColor bool
 }
 
 func (s prettyStack) parse(debugStack []byte, rvr interface{}) ([]byte, error) {
 	var err error
-	useColor := true
+	useColor := !s.noColor
 	buf := &bytes.Buffer{}
 
 	cW(buf, false, bRed, "\n")
@@ -72,7 +216,7 @@ func (s prettyStack) parse(debugStack []byte, rvr interface{}) ([]byte, error) {
 	// locate panic line, as we may have nested panics
 	for i := len(stack) - 1; i > 0; i-- {
 		lines = append(lines, stack[i])
-		if strings.HasPrefix(stack[i], "panic(0x") {
+		if strings.HasPrefix(stack[i], "panic(") {
 			lines = lines[0 : len(lines)-2] // remove boilerplate
 			break
 		}
@@ -127,6 +271,9 @@ func (s prettyStack) decorateFuncCallLine(line string, useColor bool, num int) (
 	idx = strings.LastIndex(pkg, string(os.PathSeparator))
 	if idx < 0 {
 		idx = strings.Index(pkg, ".")
+		if idx < 0 {
+			return "", errors.New("not a func call line")
+		}
 		method = pkg[idx:]
 		pkg = pkg[0:idx]
 	} else {
@@ -190,3 +337,42 @@ func (s prettyStack) decorateSourceLine(line string, useColor bool, num int) (st
 
 	return buf.String(), nil
 }
+
+// StackFrame is a frame of the stack of a panic.
+type StackFrame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// parseStack parses the frames of a debug.Stack of a panic, from the
+// panicking frame down to the goroutine entry point.
+func parseStack(debugStack []byte) []StackFrame {
+	lines := strings.Split(string(debugStack), "\n")
+
+	var frames []StackFrame
+	for i := 1; i+1 < len(lines); i += 2 {
+		fn, src := strings.TrimSpace(lines[i]), strings.TrimSpace(lines[i+1])
+		if fn == "" {
+			break
+		}
+		// Drop the frames of the recovering, the panic is where it's at.
+		if strings.HasPrefix(fn, "panic(") {
+			frames = frames[:0]
+			continue
+		}
+		if idx := strings.LastIndex(fn, "("); idx > 0 {
+			fn = fn[:idx]
+		}
+		frame := StackFrame{Func: fn, File: src}
+		if idx := strings.Index(src, " +0x"); idx > 0 {
+			src = src[:idx]
+		}
+		if idx := strings.LastIndex(src, ":"); idx > 0 {
+			frame.File = src[:idx]
+			frame.Line, _ = strconv.Atoi(src[idx+1:])
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
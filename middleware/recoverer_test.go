@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func panickingHandler(http.ResponseWriter, *http.Request) { panic("foo") }
+
+func TestRecoverer(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Recoverer)
+	r.Get("/", panickingHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assertEqual(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestRecovererAbortHandler(t *testing.T) {
+	defer func() {
+		assertEqual(t, http.ErrAbortHandler, recover())
+	}()
+
+	r := chi.NewRouter()
+	r.Use(Recoverer)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	t.Fatal("expecting http.ErrAbortHandler to be re-panicked")
+}
+
+func TestRecovererWithOpts(t *testing.T) {
+	var reported []StackFrame
+	r := chi.NewRouter()
+	r.Use(RecovererWithOpts(RecovererOpts{
+		Renderer: func(w http.ResponseWriter, r *http.Request, rvr interface{}) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("recovered: " + rvr.(string)))
+		},
+		Reporter: func(r *http.Request, rvr interface{}, stack []StackFrame) {
+			reported = stack
+		},
+	}))
+	r.Get("/", panickingHandler)
+	r.Get("/committed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("foo")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assertEqual(t, http.StatusServiceUnavailable, w.Code)
+	assertEqual(t, "recovered: foo", w.Body.String())
+	if len(reported) == 0 || !strings.HasSuffix(reported[0].Func, "panickingHandler") {
+		t.Fatalf("unexpected reported stack %+v", reported)
+	}
+
+	// The response can't be changed once committed.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/committed", nil))
+	assertEqual(t, http.StatusOK, w.Code)
+	assertEqual(t, "partial", w.Body.String())
+}
+
+func TestRecovererDevelopment(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(RequestID)
+	r.Use(RecovererWithOpts(RecovererOpts{Development: true}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		panic("<script>")
+	})
+
+	req := httptest.NewRequest("GET", "/?q=1", nil)
+	req.Header.Set("X-Test", "<value>")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("Cookie", "session=secret-session")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assertEqual(t, http.StatusInternalServerError, w.Code)
+	assertEqual(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	for _, s := range []string{
+		"<h1>panic: &lt;script&gt;</h1>",
+		"TestRecovererDevelopment",
+		"<td>/?q=1</td>",
+		"<tr><th>X-Test</th><td>&lt;value&gt;</td></tr>",
+		"<th>Request ID</th>",
+		"<tr><th>Authorization</th><td>[redacted]</td></tr>",
+		"<tr><th>Cookie</th><td>[redacted]</td></tr>",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("expecting the page to contain %q, got:\n%s", s, body)
+		}
+	}
+	if strings.Contains(body, "secret-") {
+		t.Errorf("expecting credentials to be redacted, got:\n%s", body)
+	}
+	if strings.Contains(body, "\x1b[") {
+		t.Errorf("unexpected terminal colors in the page")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
 // Key to use when setting the request ID.
@@ -64,18 +65,146 @@ func init() {
 // where "random" is a base62 random string that uniquely identifies this go
 // process, and where the last number is an atomically incremented request
 // counter.
+//
+// Incoming request IDs of the RequestIDHeader are reused when valid, see
+// ValidRequestID. See RequestIDWithOpts for other ID formats.
 func RequestID(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		requestID := r.Header.Get(RequestIDHeader)
-		if requestID == "" {
-			myid := atomic.AddUint64(&reqid, 1)
-			requestID = fmt.Sprintf("%s-%06d", prefix, myid)
+	return RequestIDWithOpts(RequestIDOpts{})(next)
+}
+
+// RequestIDOpts represents a set of request ID options.
+type RequestIDOpts struct {
+	// Header is the request header incoming request IDs are read from.
+	// Defaults to RequestIDHeader.
+	Header string
+
+	// ResponseHeader is the response header the request ID is echoed in, if
+	// any, for example "X-Request-Id".
+	ResponseHeader string
+
+	// Generator generates the IDs of requests without a valid incoming ID.
+	// Defaults to RequestIDCounter.
+	Generator func(r *http.Request) string
+
+	// Validator reports whether an incoming request ID can be reused. Invalid
+	// IDs are replaced by generated ones. Defaults to ValidRequestID with
+	// MaxLength.
+	Validator func(id string) bool
+
+	// MaxLength is the maximum length of incoming request IDs for the default
+	// Validator. Defaults to 128.
+	MaxLength int
+}
+
+// RequestIDWithOpts is a middleware that injects a request ID into the context
+// of each request, using passed RequestIDOpts. For example, to use and echo
+// the trace-id of W3C Trace Context headers:
+//
+//  r.Use(middleware.RequestIDWithOpts(middleware.RequestIDOpts{
+//    Header:         "X-Correlation-Id",
+//    ResponseHeader: "X-Correlation-Id",
+//    Generator:      middleware.RequestIDTraceParent,
+//  }))
+func RequestIDWithOpts(opts RequestIDOpts) func(next http.Handler) http.Handler {
+	if opts.Generator == nil {
+		opts.Generator = RequestIDCounter
+	}
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = 128
+	}
+	if opts.Validator == nil {
+		maxLength := opts.MaxLength
+		opts.Validator = func(id string) bool {
+			return ValidRequestID(id, maxLength)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			header := opts.Header
+			if header == "" {
+				header = RequestIDHeader
+			}
+			requestID := r.Header.Get(header)
+			if requestID == "" || !opts.Validator(requestID) {
+				requestID = opts.Generator(r)
+			}
+			if opts.ResponseHeader != "" {
+				w.Header().Set(opts.ResponseHeader, requestID)
+			}
+			ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		}
-		ctx = context.WithValue(ctx, RequestIDKey, requestID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ValidRequestID reports whether an incoming request ID is at most maxLength
+// long and only made of visible ASCII characters, so that it can't tamper
+// with the logs it's written to.
+func ValidRequestID(id string, maxLength int) bool {
+	if len(id) == 0 || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDCounter generates request IDs of the form
+// "host.example.com/random-000001", see RequestID.
+func RequestIDCounter(r *http.Request) string {
+	return fmt.Sprintf("%s-%06d", prefix, NextRequestID())
+}
+
+// RequestIDUUID generates random (version 4) UUID request IDs.
+func RequestIDUUID(r *http.Request) string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// RequestIDULID generates ULID request IDs, which sort by creation time.
+// See https://github.com/ulid/spec.
+func RequestIDULID(r *http.Request) string {
+	const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+	rand.Read(b[6:])
+
+	// Encode the 128 bits as 26 base32 characters, the first one holding
+	// only 3 bits.
+	var id [26]byte
+	hi := uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+		uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7])
+	lo := uint64(b[8])<<56 | uint64(b[9])<<48 | uint64(b[10])<<40 | uint64(b[11])<<32 |
+		uint64(b[12])<<24 | uint64(b[13])<<16 | uint64(b[14])<<8 | uint64(b[15])
+	for i := 25; i >= 0; i-- {
+		id[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id[:])
+}
+
+// RequestIDTraceParent reuses the trace-id of the W3C Trace Context
+// traceparent request header as request ID, or generates a new random
+// trace-id.
+func RequestIDTraceParent(r *http.Request) string {
+	if tp, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+		return tp.traceID
 	}
-	return http.HandlerFunc(fn)
+	return randomHex(16)
 }
 
 // GetReqID returns a request ID from the given context if one is present.
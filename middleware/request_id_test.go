@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -69,3 +70,67 @@ func TestRequestID(t *testing.T) {
 		}
 	}
 }
+
+func TestRequestIDWithOpts(t *testing.T) {
+	uuidRe := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidRe := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+	tests := map[string]struct {
+		opts   RequestIDOpts
+		header http.Header
+		check  func(id string) bool
+	}{
+		"valid incoming ID is reused": {
+			RequestIDOpts{Header: "X-Correlation-Id", Generator: RequestIDUUID},
+			http.Header{"X-Correlation-Id": {"abc-123"}},
+			func(id string) bool { return id == "abc-123" },
+		},
+		"too long incoming ID is replaced": {
+			RequestIDOpts{Generator: RequestIDUUID, MaxLength: 8},
+			http.Header{"X-Request-Id": {"abcdefghijk"}},
+			uuidRe.MatchString,
+		},
+		"incoming ID with control characters is replaced": {
+			RequestIDOpts{Generator: RequestIDULID},
+			http.Header{"X-Request-Id": {"abc\ndef"}},
+			ulidRe.MatchString,
+		},
+		"custom validator": {
+			RequestIDOpts{Generator: RequestIDUUID, Validator: uuidRe.MatchString},
+			http.Header{"X-Request-Id": {"abc"}},
+			uuidRe.MatchString,
+		},
+		"traceparent trace-id": {
+			RequestIDOpts{Generator: RequestIDTraceParent},
+			http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+			func(id string) bool { return id == "4bf92f3577b34da6a3ce929d0e0e4736" },
+		},
+		"invalid traceparent": {
+			RequestIDOpts{Generator: RequestIDTraceParent},
+			http.Header{"Traceparent": {"00-00000000000000000000000000000000-00f067aa0ba902b7-01"}},
+			regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.opts.ResponseHeader = "X-Request-Id"
+			var got string
+			h := RequestIDWithOpts(test.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = GetReqID(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if !test.check(got) {
+				t.Fatalf("unexpected request ID %q", got)
+			}
+			assertEqual(t, got, w.Header().Get("X-Request-Id"))
+		})
+	}
+}
@@ -30,15 +30,13 @@ import (
 // r := chi.NewRouter()
 //
 // r.Use(middleware.RouteHeaders().
-//   Route("Origin", "https://app.skyweaver.net", cors.Handler(cors.Options{
+//   Route("Origin", "https://app.skyweaver.net", middleware.CORS(middleware.CORSOptions{
 // 	   AllowedOrigins:   []string{"https://api.skyweaver.net"},
-// 	   AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 // 	   AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
 // 	   AllowCredentials: true, // <----------<<< allow credentials
 //   })).
-//   Route("Origin", "*", cors.Handler(cors.Options{
+//   Route("Origin", "*", middleware.CORS(middleware.CORSOptions{
 // 	   AllowedOrigins:   []string{"*"},
-// 	   AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 // 	   AllowedHeaders:   []string{"Accept", "Content-Type"},
 // 	   AllowCredentials: false, // <----------<<< do not allow credentials
 //   })).
@@ -1,8 +1,12 @@
 package middleware
 
 import (
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,6 +14,7 @@ const (
 	errCapacityExceeded = "Server capacity exceeded."
 	errTimedOut         = "Timed out while waiting for a pending request to complete."
 	errContextCanceled  = "Context was canceled."
+	errDeadlineTooShort = "Request deadline is shorter than the expected wait."
 )
 
 var (
@@ -22,6 +27,32 @@ type ThrottleOpts struct {
 	Limit          int
 	BacklogLimit   int
 	BacklogTimeout time.Duration
+
+	// Metrics exposes the limit, backlog and rejection counts of the
+	// throttle, under the throttle label Name.
+	Metrics *Metrics
+	Name    string
+
+	// State, when set, is bound to the throttle to read its current limit,
+	// in-flight and backlog requests. It can't be shared between throttles.
+	State *ThrottleState
+
+	// AdaptiveLimit adjusts the limit from the latency of the processed
+	// requests, between MinLimit and MaxLimit, starting from Limit. See
+	// AIMDLimit and GradientLimit. A GradientLimit is copied, for each
+	// throttle to keep its own latency averages.
+	AdaptiveLimit AdaptiveLimit
+
+	// MinLimit is the lowest adaptive limit. Defaults to 1.
+	MinLimit int
+
+	// MaxLimit is the highest adaptive limit. Defaults to 1000.
+	MaxLimit int
+
+	// Classifier returns the priority of a request, and its fair sharing
+	// key, such as a tenant. The backlog serves the requests of the highest
+	// priority first, and round-robin between the keys of a same priority.
+	Classifier func(r *http.Request) (priority int, key string)
 }
 
 // Throttle is a middleware that limits number of currently processed requests
@@ -40,6 +71,40 @@ func ThrottleBacklog(limit, backlogLimit int, backlogTimeout time.Duration) func
 }
 
 // ThrottleWithOpts is a middleware that limits number of currently processed requests using passed ThrottleOpts.
+//
+// With an AdaptiveLimit, the limit adjusts to the latency of the requests, in
+// the spirit of https://github.com/Netflix/concurrency-limits. For example:
+//
+//  r.Use(middleware.ThrottleWithOpts(middleware.ThrottleOpts{
+//    Limit:          20,
+//    BacklogLimit:   100,
+//    BacklogTimeout: time.Second,
+//    AdaptiveLimit:  &middleware.GradientLimit{},
+//    MaxLimit:       200,
+//  }))
+//
+// The backlog holds up to BacklogLimit requests over the current limit.
+// Requests responded with a 503 Service Unavailable or a 504 Gateway Timeout,
+// such as by the Timeout middleware, are reported to the AdaptiveLimit as
+// dropped.
+//
+// With a Classifier, the backlog serves the waiting requests by priority, for
+// example health checks before bulk exports, and shares the tokens fairly
+// between the keys of a same priority, like tenants:
+//
+//  Classifier: func(r *http.Request) (int, string) {
+//    if r.URL.Path == "/health" {
+//      return 10, ""
+//    }
+//    return 0, r.Header.Get("X-Tenant")
+//  },
+//
+// The Classifier is called before the request reaches the routes below the
+// throttle, so to classify by route pattern, add the throttle to each route
+// with r.With rather than to the router with r.Use.
+//
+// Requests with a context deadline shorter than their expected wait in the
+// backlog, estimated from the average processing time, are rejected early.
 func ThrottleWithOpts(opts ThrottleOpts) func(http.Handler) http.Handler {
 	if opts.Limit < 1 {
 		panic("chi/middleware: Throttle expects limit > 0")
@@ -49,19 +114,47 @@ func ThrottleWithOpts(opts ThrottleOpts) func(http.Handler) http.Handler {
 		panic("chi/middleware: Throttle expects backlogLimit to be positive")
 	}
 
-	t := throttler{
-		tokens:         make(chan token, opts.Limit),
-		backlogTokens:  make(chan token, opts.Limit+opts.BacklogLimit),
+	maxLimit := opts.Limit
+	if opts.AdaptiveLimit != nil {
+		if opts.MinLimit <= 0 {
+			opts.MinLimit = 1
+		}
+		if opts.MaxLimit <= 0 {
+			opts.MaxLimit = 1000
+		}
+		if opts.Limit < opts.MinLimit || opts.Limit > opts.MaxLimit {
+			panic("chi/middleware: Throttle expects minLimit <= limit <= maxLimit")
+		}
+		maxLimit = opts.MaxLimit
+
+		if g, ok := opts.AdaptiveLimit.(*GradientLimit); ok {
+			limit := *g
+			limit.longRTT = 0
+			opts.AdaptiveLimit = &limit
+		}
+	}
+
+	t := &throttler{
+		tokens:         newTokenPool(opts.Limit, maxLimit),
+		backlogTokens:  newTokenPool(opts.Limit+opts.BacklogLimit, maxLimit+opts.BacklogLimit),
 		backlogTimeout: opts.BacklogTimeout,
 		retryAfterFn:   opts.RetryAfterFn,
+		stats:          &throttleStats{limit: int64(opts.Limit)},
+		classifier:     opts.Classifier,
+		adaptive:       opts.AdaptiveLimit,
+		backlogLimit:   opts.BacklogLimit,
+		minLimit:       opts.MinLimit,
+		maxLimit:       opts.MaxLimit,
+		estimate:       float64(opts.Limit),
 	}
-
-	// Filling tokens.
-	for i := 0; i < opts.Limit+opts.BacklogLimit; i++ {
-		if i < opts.Limit {
-			t.tokens <- token{}
+	if opts.Metrics != nil {
+		opts.Metrics.registerThrottle(opts.Name, t.stats)
+	}
+	if opts.State != nil {
+		if opts.State.stats != nil {
+			panic("chi/middleware: Throttle expects a ThrottleState per throttle")
 		}
-		t.backlogTokens <- token{}
+		opts.State.stats = t.stats
 	}
 
 	return func(next http.Handler) http.Handler {
@@ -71,37 +164,76 @@ func ThrottleWithOpts(opts ThrottleOpts) func(http.Handler) http.Handler {
 			select {
 
 			case <-ctx.Done():
+				atomic.AddInt64(&t.stats.rejectedCanceled, 1)
 				t.setRetryAfterHeaderIfNeeded(w, true)
 				http.Error(w, errContextCanceled, http.StatusTooManyRequests)
 				return
 
-			case btok := <-t.backlogTokens:
-				timer := time.NewTimer(t.backlogTimeout)
-
+			case btok := <-t.backlogTokens.ch:
 				defer func() {
-					t.backlogTokens <- btok
+					t.backlogTokens.put(btok)
 				}()
 
-				select {
-				case <-timer.C:
-					t.setRetryAfterHeaderIfNeeded(w, false)
-					http.Error(w, errTimedOut, http.StatusTooManyRequests)
-					return
-				case <-ctx.Done():
-					timer.Stop()
-					t.setRetryAfterHeaderIfNeeded(w, true)
-					http.Error(w, errContextCanceled, http.StatusTooManyRequests)
-					return
-				case tok := <-t.tokens:
-					defer func() {
+				waiter := &throttleWaiter{ready: make(chan token, 1)}
+				if t.classifier != nil {
+					waiter.priority, waiter.key = t.classifier(r)
+				}
+				start := time.Now()
+				tok, ok, ahead := t.tokens.get(waiter)
+				if !ok {
+					if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < t.expectedWait(ahead) {
+						t.tokens.cancel(waiter)
+						atomic.AddInt64(&t.stats.rejectedDeadline, 1)
+						t.setRetryAfterHeaderIfNeeded(w, false)
+						http.Error(w, errDeadlineTooShort, http.StatusTooManyRequests)
+						return
+					}
+
+					timer := time.NewTimer(t.backlogTimeout)
+					atomic.AddInt64(&t.stats.waiting, 1)
+					select {
+					case <-timer.C:
+						atomic.AddInt64(&t.stats.waiting, -1)
+						atomic.AddInt64(&t.stats.rejectedTimeout, 1)
+						t.tokens.cancel(waiter)
+						t.setRetryAfterHeaderIfNeeded(w, false)
+						http.Error(w, errTimedOut, http.StatusTooManyRequests)
+						return
+					case <-ctx.Done():
+						atomic.AddInt64(&t.stats.waiting, -1)
+						atomic.AddInt64(&t.stats.rejectedCanceled, 1)
 						timer.Stop()
-						t.tokens <- tok
-					}()
+						t.tokens.cancel(waiter)
+						t.setRetryAfterHeaderIfNeeded(w, true)
+						http.Error(w, errContextCanceled, http.StatusTooManyRequests)
+						return
+					case tok = <-waiter.ready:
+						atomic.AddInt64(&t.stats.waiting, -1)
+						timer.Stop()
+					}
+				}
+				t.stats.observeWait(time.Since(start))
+
+				inflight := atomic.AddInt64(&t.stats.processing, 1)
+				defer func() {
+					atomic.AddInt64(&t.stats.processing, -1)
+					t.tokens.put(tok)
+				}()
+
+				start = time.Now()
+				if t.adaptive == nil {
 					next.ServeHTTP(w, r)
+					t.processed(time.Since(start), int(inflight), false)
+					return
 				}
+				ww := NewWrapResponseWriter(w, r.ProtoMajor)
+				next.ServeHTTP(ww, r)
+				status := ww.Status()
+				t.processed(time.Since(start), int(inflight), status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout)
 				return
 
 			default:
+				atomic.AddInt64(&t.stats.rejectedCapacity, 1)
 				t.setRetryAfterHeaderIfNeeded(w, false)
 				http.Error(w, errCapacityExceeded, http.StatusTooManyRequests)
 				return
@@ -117,16 +249,400 @@ type token struct{}
 
 // throttler limits number of currently processed requests at a time.
 type throttler struct {
-	tokens         chan token
-	backlogTokens  chan token
+	tokens         *tokenPool
+	backlogTokens  *tokenPool
 	retryAfterFn   func(ctxDone bool) time.Duration
 	backlogTimeout time.Duration
+	stats          *throttleStats
+	classifier     func(r *http.Request) (int, string)
+
+	adaptive     AdaptiveLimit
+	backlogLimit int
+	minLimit     int
+	maxLimit     int
+
+	mu          sync.Mutex
+	estimate    float64
+	serviceTime float64
+}
+
+// processed records the latency of a processed request, and updates the
+// adaptive limit.
+func (t *throttler) processed(rtt time.Duration, inflight int, dropped bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.serviceTime == 0 {
+		t.serviceTime = rtt.Seconds()
+	} else {
+		t.serviceTime = 0.9*t.serviceTime + 0.1*rtt.Seconds()
+	}
+	if t.adaptive == nil {
+		return
+	}
+
+	t.estimate = math.Max(float64(t.minLimit), math.Min(float64(t.maxLimit),
+		t.adaptive.Update(t.estimate, rtt, inflight, dropped)))
+	limit := int(t.estimate)
+	t.tokens.resize(limit)
+	t.backlogTokens.resize(limit + t.backlogLimit)
+	atomic.StoreInt64(&t.stats.limit, int64(limit))
+}
+
+// expectedWait estimates the wait of a request with a number of requests
+// ahead of it in the backlog, from the average processing time.
+func (t *throttler) expectedWait(ahead int) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	limit := math.Max(1, math.Floor(t.estimate))
+	return time.Duration(t.serviceTime * float64(ahead+1) / limit * float64(time.Second))
+}
+
+// tokenPool is a resizable pool of tokens. Shrinking it retires the tokens
+// as they're put back. Tokens are handed to the waiters of the queue first,
+// so the channel only holds tokens when nobody is waiting.
+type tokenPool struct {
+	ch chan token
+
+	mu    sync.Mutex
+	size  int
+	debt  int
+	queue throttleQueue
+}
+
+func newTokenPool(size, maxSize int) *tokenPool {
+	p := &tokenPool{ch: make(chan token, maxSize), size: size}
+	for i := 0; i < size; i++ {
+		p.ch <- token{}
+	}
+	return p
+}
+
+// put puts a token back in the pool, unless it's retired.
+func (p *tokenPool) put(tok token) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.debt > 0 {
+		p.debt--
+		return
+	}
+	if waiter := p.queue.pop(); waiter != nil {
+		waiter.ready <- tok
+		return
+	}
+	p.ch <- tok
+}
+
+// get returns a token right away, or queues the waiter and returns the number
+// of requests ahead of it.
+func (p *tokenPool) get(waiter *throttleWaiter) (token, bool, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case tok := <-p.ch:
+		return tok, true, 0
+	default:
+		return token{}, false, p.queue.push(waiter)
+	}
+}
+
+// cancel removes a waiter from the queue, and puts back the token it may have
+// been handed meanwhile.
+func (p *tokenPool) cancel(waiter *throttleWaiter) {
+	p.mu.Lock()
+	removed := p.queue.remove(waiter)
+	p.mu.Unlock()
+	if !removed {
+		p.put(<-waiter.ready)
+	}
+}
+
+// resize grows or shrinks the pool, which never exceeds its initial max
+// size as the tokens in use and in the pool are size + debt.
+func (p *tokenPool) resize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ; p.size < size; p.size++ {
+		if p.debt > 0 {
+			p.debt--
+		} else if waiter := p.queue.pop(); waiter != nil {
+			waiter.ready <- token{}
+		} else {
+			p.ch <- token{}
+		}
+	}
+	for ; p.size > size; p.size-- {
+		p.debt++
+	}
+}
+
+// throttleWaiter is a request waiting in the backlog.
+type throttleWaiter struct {
+	priority int
+	key      string
+	ready    chan token
+	removed  bool
+}
+
+// throttleQueue orders the waiters of the backlog by descending priority, and
+// round-robin between the keys of a same priority.
+type throttleQueue struct {
+	levels []*throttleLevel
+}
+
+type throttleLevel struct {
+	priority int
+	count    int
+	keys     []string
+	waiters  map[string][]*throttleWaiter
+}
+
+// push queues a waiter, and returns the number of waiters ahead of it.
+func (q *throttleQueue) push(waiter *throttleWaiter) int {
+	i := sort.Search(len(q.levels), func(i int) bool {
+		return q.levels[i].priority <= waiter.priority
+	})
+	if i == len(q.levels) || q.levels[i].priority != waiter.priority {
+		q.levels = append(q.levels, nil)
+		copy(q.levels[i+1:], q.levels[i:])
+		q.levels[i] = &throttleLevel{priority: waiter.priority, waiters: make(map[string][]*throttleWaiter)}
+	}
+	l := q.levels[i]
+	if len(l.waiters[waiter.key]) == 0 {
+		l.keys = append(l.keys, waiter.key)
+	}
+	l.waiters[waiter.key] = append(l.waiters[waiter.key], waiter)
+	l.count++
+
+	ahead := 0
+	for _, l := range q.levels[:i+1] {
+		ahead += l.count
+	}
+	return ahead - 1
+}
+
+// pop dequeues the next waiter, if any.
+func (q *throttleQueue) pop() *throttleWaiter {
+	for len(q.levels) > 0 {
+		l := q.levels[0]
+		key := l.keys[0]
+		l.keys = l.keys[1:]
+		waiters := l.waiters[key]
+		waiter := waiters[0]
+		if len(waiters) > 1 {
+			l.waiters[key] = waiters[1:]
+			l.keys = append(l.keys, key)
+		} else {
+			delete(l.waiters, key)
+		}
+		if waiter.removed {
+			continue
+		}
+		q.release(0)
+		return waiter
+	}
+	return nil
+}
+
+// remove removes a waiter, unless it was dequeued already. The waiter is
+// only marked as removed, and skipped when dequeued.
+func (q *throttleQueue) remove(waiter *throttleWaiter) bool {
+	for i, l := range q.levels {
+		if l.priority != waiter.priority {
+			continue
+		}
+		for _, w := range l.waiters[waiter.key] {
+			if w == waiter {
+				waiter.removed = true
+				q.release(i)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// release decrements the count of a level, and drops it once empty.
+func (q *throttleQueue) release(i int) {
+	l := q.levels[i]
+	l.count--
+	if l.count == 0 {
+		q.levels = append(q.levels[:i], q.levels[i+1:]...)
+	}
+}
+
+// throttleStats counts the requests of a throttler.
+type throttleStats struct {
+	limit            int64
+	processing       int64
+	waiting          int64
+	rejectedCapacity int64
+	rejectedTimeout  int64
+	rejectedCanceled int64
+	rejectedDeadline int64
+
+	// wait is the histogram of the backlog waits, once registered to
+	// Metrics.
+	mu   sync.Mutex
+	wait *histogram
+}
+
+// ThrottleState reads the state of a throttle, see ThrottleOpts.State:
+//
+//  state := &middleware.ThrottleState{}
+//  r.Use(middleware.ThrottleWithOpts(middleware.ThrottleOpts{
+//    Limit:         20,
+//    AdaptiveLimit: &middleware.AIMDLimit{},
+//    State:         state,
+//  }))
+//
+//  log.Printf("limit: %d, in-flight: %d", state.Limit(), state.InFlight())
+type ThrottleState struct {
+	stats *throttleStats
+}
+
+// Limit returns the current limit of the throttle, which changes over time
+// with an AdaptiveLimit.
+func (s *ThrottleState) Limit() int {
+	if s.stats == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&s.stats.limit))
+}
+
+// InFlight returns the number of requests being processed.
+func (s *ThrottleState) InFlight() int {
+	if s.stats == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&s.stats.processing))
+}
+
+// Backlog returns the number of requests waiting in the backlog.
+func (s *ThrottleState) Backlog() int {
+	if s.stats == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&s.stats.waiting))
+}
+
+func (s *throttleStats) observeWait(d time.Duration) {
+	s.mu.Lock()
+	if s.wait != nil {
+		s.wait.observe(d.Seconds())
+	}
+	s.mu.Unlock()
 }
 
 // setRetryAfterHeaderIfNeeded sets Retry-After HTTP header if corresponding retryAfterFn option of throttler is initialized.
-func (t throttler) setRetryAfterHeaderIfNeeded(w http.ResponseWriter, ctxDone bool) {
+func (t *throttler) setRetryAfterHeaderIfNeeded(w http.ResponseWriter, ctxDone bool) {
 	if t.retryAfterFn == nil {
 		return
 	}
 	w.Header().Set("Retry-After", strconv.Itoa(int(t.retryAfterFn(ctxDone).Seconds())))
 }
+
+// AdaptiveLimit computes the adaptive limit of a throttle, see ThrottleOpts.
+// Update is called with the current limit, the latency of a processed
+// request, the number of requests being processed when it started, and
+// whether the request was dropped. Calls are serialized by the throttle, so a
+// stateful AdaptiveLimit must not be shared between throttles.
+type AdaptiveLimit interface {
+	Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// AIMDLimit is an additive increase, multiplicative decrease AdaptiveLimit.
+// The limit grows by one while requests are fast and the throttle is busy,
+// and is multiplied by the BackoffRatio when requests are slow or dropped.
+type AIMDLimit struct {
+	// Timeout is the latency over which requests are slow. Defaults to 1s.
+	Timeout time.Duration
+
+	// BackoffRatio multiplies the limit on slow or dropped requests, between
+	// 0.5 and 1. Defaults to 0.9.
+	BackoffRatio float64
+}
+
+// Update returns the new limit.
+func (l *AIMDLimit) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	timeout, ratio := l.Timeout, l.BackoffRatio
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	if ratio <= 0 {
+		ratio = 0.9
+	}
+
+	if dropped || rtt > timeout {
+		return limit * ratio
+	}
+	// Only grow the limit when it's actually limiting.
+	if float64(inflight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// GradientLimit is an AdaptiveLimit following the gradient of the latency of
+// requests: it compares recent latencies with their long term average, and
+// shrinks the limit when requests get slower, as when they queue up. It
+// is inspired by the Gradient2 limit of Netflix's concurrency-limits.
+//
+// ThrottleWithOpts copies the GradientLimit, so the same options can be used
+// for several throttles. Otherwise, it must not be shared.
+type GradientLimit struct {
+	// Tolerance is the ratio of latency increase tolerated before shrinking
+	// the limit. Defaults to 1.5.
+	Tolerance float64
+
+	// Smoothing damps the limit changes, between 0 and 1. Defaults to 0.2.
+	Smoothing float64
+
+	// Window is the number of requests of the long term average latency.
+	// Defaults to 600.
+	Window int
+
+	longRTT float64
+}
+
+// Update returns the new limit.
+func (l *GradientLimit) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	tolerance, smoothing, window := l.Tolerance, l.Smoothing, l.Window
+	if tolerance <= 0 {
+		tolerance = 1.5
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+	if window <= 0 {
+		window = 600
+	}
+
+	shortRTT := rtt.Seconds()
+	if l.longRTT == 0 {
+		l.longRTT = shortRTT
+	} else {
+		alpha := 2 / float64(window+1)
+		l.longRTT = l.longRTT*(1-alpha) + shortRTT*alpha
+	}
+	// Decay the long term average when recovering from a latency spike, for
+	// it to catch up with the latencies of the healthy state.
+	if shortRTT > 0 && l.longRTT/shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+
+	// Don't grow the limit when it's not limiting.
+	if !dropped && float64(inflight) < limit/2 {
+		return limit
+	}
+
+	gradient := 1.0
+	if shortRTT > 0 {
+		gradient = math.Max(0.5, math.Min(1, tolerance*l.longRTT/shortRTT))
+	}
+	if dropped {
+		gradient = 0.5
+	}
+	newLimit := limit*gradient + math.Sqrt(limit)
+	return limit*(1-smoothing) + newLimit*smoothing
+}
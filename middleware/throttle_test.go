@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -252,3 +255,285 @@ func TestThrottleMaximum(t *testing.T) {
 
 	wg.Wait()
 }*/
+
+func TestThrottleAdaptiveLimit(t *testing.T) {
+	metrics := NewMetrics(MetricsOpts{})
+	var slow int32 = 1
+
+	r := chi.NewRouter()
+	r.Use(ThrottleWithOpts(ThrottleOpts{
+		Limit:          10,
+		BacklogLimit:   20,
+		BacklogTimeout: time.Second,
+		AdaptiveLimit:  &AIMDLimit{Timeout: 50 * time.Millisecond, BackoffRatio: 0.5},
+		MinLimit:       2,
+		MaxLimit:       12,
+		Metrics:        metrics,
+		Name:           "api",
+	}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(60 * time.Millisecond)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	limit := func() string {
+		w := httptest.NewRecorder()
+		metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if strings.HasPrefix(line, `http_throttle_limit{throttle="api"} `) {
+				return strings.TrimPrefix(line, `http_throttle_limit{throttle="api"} `)
+			}
+		}
+		return ""
+	}
+
+	// Slow requests back off down to MinLimit.
+	for i := 0; i < 3; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	assertEqual(t, "2", limit())
+
+	// Fast requests of a busy throttle grow the limit up to MaxLimit.
+	atomic.StoreInt32(&slow, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}
+		}()
+	}
+	wg.Wait()
+	assertEqual(t, "12", limit())
+}
+
+func TestTokenPoolResize(t *testing.T) {
+	p := newTokenPool(2, 4)
+	tok := <-p.ch
+
+	p.resize(1)
+	assertEqual(t, 1, len(p.ch))
+	p.put(tok)
+	assertEqual(t, 1, len(p.ch))
+
+	p.resize(4)
+	assertEqual(t, 4, len(p.ch))
+}
+
+func TestGradientLimit(t *testing.T) {
+	l := &GradientLimit{}
+	limit := 20.0
+	for i := 0; i < 10; i++ {
+		limit = l.Update(limit, 10*time.Millisecond, 20, false)
+	}
+	if limit <= 20 {
+		t.Fatalf("expecting the limit to grow with steady latencies, got %v", limit)
+	}
+
+	grown := limit
+	for i := 0; i < 10; i++ {
+		limit = l.Update(limit, 100*time.Millisecond, int(limit), false)
+	}
+	if limit >= grown {
+		t.Fatalf("expecting the limit to shrink with rising latencies, got %v", limit)
+	}
+}
+
+func TestThrottleGradientLimitCopy(t *testing.T) {
+	l := &GradientLimit{Window: 10}
+	opts := ThrottleOpts{Limit: 5, AdaptiveLimit: l}
+
+	for _, d := range []time.Duration{time.Millisecond, 5 * time.Millisecond} {
+		d := d
+		handler := ThrottleWithOpts(opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(d)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	if l.longRTT != 0 {
+		t.Fatalf("expecting each throttle to keep its own latency average, got %v in the options", l.longRTT)
+	}
+}
+
+func TestThrottleState(t *testing.T) {
+	state := &ThrottleState{}
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+
+	handler := ThrottleWithOpts(ThrottleOpts{
+		Limit:          2,
+		BacklogLimit:   5,
+		BacklogTimeout: time.Second,
+		State:          state,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	assertEqual(t, 2, state.Limit())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+	<-started
+	<-started
+	for state.Backlog() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assertEqual(t, 2, state.InFlight())
+
+	close(release)
+	wg.Wait()
+	assertEqual(t, 0, state.InFlight())
+	assertEqual(t, 0, state.Backlog())
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expecting a panic when sharing a ThrottleState")
+		}
+	}()
+	ThrottleWithOpts(ThrottleOpts{Limit: 1, State: state})
+}
+
+func TestThrottleQueue(t *testing.T) {
+	var q throttleQueue
+	waiter := func(priority int, key string) *throttleWaiter {
+		return &throttleWaiter{priority: priority, key: key}
+	}
+	a1, a2, a3, b1, h1, c1 := waiter(0, "a"), waiter(0, "a"), waiter(0, "a"), waiter(0, "b"), waiter(10, ""), waiter(0, "c")
+
+	assertEqual(t, 0, q.push(a1))
+	assertEqual(t, 1, q.push(a2))
+	assertEqual(t, 2, q.push(a3))
+	assertEqual(t, 3, q.push(b1))
+	assertEqual(t, 0, q.push(h1))
+	assertEqual(t, 5, q.push(c1))
+	assertEqual(t, true, q.remove(c1))
+
+	// The highest priority first, then round-robin between the keys.
+	for _, want := range []*throttleWaiter{h1, a1, b1, a2, a3} {
+		if got := q.pop(); got != want {
+			t.Fatalf("expecting %+v, got %+v", want, got)
+		}
+	}
+	if q.pop() != nil {
+		t.Fatal("expecting an empty queue")
+	}
+	assertEqual(t, false, q.remove(a1))
+}
+
+func TestThrottlePriority(t *testing.T) {
+	metrics := NewMetrics(MetricsOpts{})
+	var mu sync.Mutex
+	var served []string
+
+	r := chi.NewRouter()
+	r.Use(ThrottleWithOpts(ThrottleOpts{
+		Limit:          1,
+		BacklogLimit:   10,
+		BacklogTimeout: 10 * time.Second,
+		Metrics:        metrics,
+		Name:           "api",
+		Classifier: func(r *http.Request) (int, string) {
+			if r.URL.Path == "/health" {
+				return 10, ""
+			}
+			return 0, r.Header.Get("X-Tenant")
+		},
+	}))
+	release := make(chan struct{})
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			<-release
+			return
+		}
+		mu.Lock()
+		served = append(served, r.Header.Get("X-Tenant")+r.URL.Path)
+		mu.Unlock()
+	})
+
+	backlog := func(n int) {
+		want := `http_throttle_backlog{throttle="api"} ` + strconv.Itoa(n) + "\n"
+		for i := 0; ; i++ {
+			w := httptest.NewRecorder()
+			metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+			if strings.Contains(w.Body.String(), want) {
+				return
+			}
+			if i > 1000 {
+				t.Fatalf("expecting a backlog of %d", n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	send := func(path, tenant string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("X-Tenant", tenant)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+
+	send("/block", "")
+	time.Sleep(10 * time.Millisecond)
+	for i, req := range []struct{ path, tenant string }{
+		{"/export/1", "a"}, {"/export/2", "a"}, {"/export/3", "b"}, {"/health", ""},
+	} {
+		send(req.path, req.tenant)
+		backlog(i + 1)
+	}
+	close(release)
+	wg.Wait()
+
+	assertEqual(t, []string{"/health", "a/export/1", "b/export/3", "a/export/2"}, served)
+}
+
+func TestThrottleDeadline(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(ThrottleWithOpts(ThrottleOpts{Limit: 1, BacklogLimit: 10, BacklogTimeout: 10 * time.Second}))
+	release := make(chan struct{})
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	// Learn the processing time.
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	done := make(chan struct{})
+	go func() {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	start := time.Now()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+	assertEqual(t, http.StatusTooManyRequests, w.Code)
+	assertEqual(t, errDeadlineTooShort+"\n", w.Body.String())
+	if time.Since(start) > 10*time.Millisecond {
+		t.Fatalf("expecting the request to be rejected right away, took %s", time.Since(start))
+	}
+	close(release)
+	<-done
+}
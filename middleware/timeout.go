@@ -1,9 +1,19 @@
 package middleware
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"sync"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 // Timeout is a middleware that cancels ctx after a given timeout and return
@@ -11,7 +21,8 @@ import (
 //
 // It's required that you select the ctx.Done() channel to check for the signal
 // if the context has reached its deadline and return, otherwise the timeout
-// signal will be just ignored.
+// signal will be just ignored. See TimeoutWithOpts to bound the response time
+// of handlers regardless.
 //
 // ie. a route/handler may look like:
 //
@@ -47,3 +58,256 @@ func Timeout(timeout time.Duration) func(next http.Handler) http.Handler {
 		return http.HandlerFunc(fn)
 	}
 }
+
+// TimeoutOpts represents a set of timeout options.
+type TimeoutOpts struct {
+	Timeout time.Duration
+
+	// Status is the status of timed out responses, for example 503 Service
+	// Unavailable. Defaults to 504 Gateway Timeout.
+	Status int
+
+	// Renderer writes the response of timed out requests. chi routers only
+	// have handlers for unmatched routes, so timeouts are rendered here, like
+	// the panics of RecovererOpts. Defaults to http.Error with the status
+	// text.
+	Renderer func(w http.ResponseWriter, r *http.Request, status int)
+
+	// Logger logs the method and route pattern of timed out handlers, once
+	// they return. Defaults to a standard logger writing to os.Stderr.
+	Logger LoggerInterface
+}
+
+// TimeoutWithOpts is a middleware that bounds the response time of requests
+// using passed TimeoutOpts, similar to http.TimeoutHandler.
+//
+// The handler runs in its own goroutine against a buffered response writer,
+// and its context is canceled after the timeout. The buffered response is
+// sent once the handler returns in time, otherwise the request is responded
+// exactly once with the timeout status, and the late writes of the handler
+// are discarded with http.ErrHandlerTimeout.
+//
+// Flushing sends the buffered response, after which a timeout can only cancel
+// the context and discard the late writes. Hijacking is supported as long as
+// nothing was written.
+//
+// Timed out handlers keep running until they return, so they should watch
+// for <-ctx.Done() to give up early, and are only logged then.
+func TimeoutWithOpts(opts TimeoutOpts) func(next http.Handler) http.Handler {
+	if opts.Timeout <= 0 {
+		panic("chi/middleware: Timeout expects a positive timeout")
+	}
+	if opts.Status == 0 {
+		opts.Status = http.StatusGatewayTimeout
+	}
+	if opts.Renderer == nil {
+		opts.Renderer = func(w http.ResponseWriter, r *http.Request, status int) {
+			http.Error(w, http.StatusText(status), status)
+		}
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), opts.Timeout)
+			defer cancel()
+
+			// The handler routes on a copy of the routing context, as the
+			// router reuses the original once the request is served, which
+			// can be before a timed out handler returns.
+			rctx := chi.RouteContext(ctx)
+			hrctx := rctx
+			if rctx != nil {
+				hrctx = copyRouteContext(rctx)
+				ctx = context.WithValue(ctx, chi.RouteCtxKey, hrctx)
+			}
+
+			tw := &timeoutWriter{w: w, header: make(http.Header)}
+			done := make(chan struct{})
+			panicChan := make(chan interface{}, 1)
+			t1 := time.Now()
+
+			go func() {
+				defer func() {
+					rvr := recover()
+
+					// The panic is only passed on while the request can still
+					// be responded, which is checked along with the timeout.
+					tw.mu.Lock()
+					expired := tw.timedOut
+					if !expired && rvr != nil {
+						panicChan <- rvr
+					}
+					tw.mu.Unlock()
+					if !expired {
+						return
+					}
+
+					route := r.URL.Path
+					if hrctx != nil && hrctx.RoutePattern() != "" {
+						route = hrctx.RoutePattern()
+					}
+					if rvr != nil {
+						opts.Logger.Print(fmt.Sprintf("chi/middleware: timed out handler of %s %s panicked after %s: %v", r.Method, route, time.Since(t1), rvr))
+					} else {
+						opts.Logger.Print(fmt.Sprintf("chi/middleware: timed out handler of %s %s returned after %s", r.Method, route, time.Since(t1)))
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			var rvr interface{}
+			timedOut := false
+			select {
+			case rvr = <-panicChan:
+			case <-done:
+			case <-ctx.Done():
+				// A handler panicking or returning right at the deadline
+				// takes precedence over the timeout.
+				tw.mu.Lock()
+				select {
+				case rvr = <-panicChan:
+				case <-done:
+				default:
+					tw.timedOut = true
+					timedOut = true
+				}
+				tw.mu.Unlock()
+			}
+			if rvr != nil {
+				panic(rvr)
+			}
+
+			if timedOut {
+				// The writer ignores the handler from now on, so it can be
+				// read without the lock.
+				if ctx.Err() == context.DeadlineExceeded && !tw.committed {
+					SetLogField(r, "timeout", true)
+					opts.Renderer(w, r, opts.Status)
+				}
+				return
+			}
+
+			if rctx != nil {
+				rctx.RoutePath = hrctx.RoutePath
+				rctx.RouteMethod = hrctx.RouteMethod
+				rctx.URLParams = hrctx.URLParams
+				rctx.RoutePatterns = hrctx.RoutePatterns
+			}
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.commit()
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// timeoutWriter buffers the response of a handler until it's committed, that
+// is when the handler returns in time or flushes.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu          sync.Mutex
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+	committed   bool
+	hijacked    bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.hijacked || tw.wroteHeader {
+		return
+	}
+	tw.code = code
+	tw.wroteHeader = true
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if !tw.wroteHeader {
+		tw.code = http.StatusOK
+		tw.wroteHeader = true
+	}
+	if tw.committed {
+		return tw.w.Write(b)
+	}
+	return tw.buf.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.hijacked {
+		return
+	}
+	tw.commit()
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	if tw.wroteHeader || tw.committed {
+		return nil, nil, errors.New("chi/middleware: can't hijack a written response")
+	}
+	hj, ok := tw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("chi/middleware: the response writer doesn't support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		tw.hijacked = true
+		tw.committed = true
+	}
+	return conn, rw, err
+}
+
+// commit sends the buffered response, once.
+func (tw *timeoutWriter) commit() {
+	if tw.committed {
+		return
+	}
+	tw.committed = true
+
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	if !tw.wroteHeader {
+		tw.code = http.StatusOK
+		tw.wroteHeader = true
+	}
+	tw.w.WriteHeader(tw.code)
+	tw.w.Write(tw.buf.Bytes())
+	tw.buf.Reset()
+}
+
+func (tw *timeoutWriter) expired() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.timedOut
+}
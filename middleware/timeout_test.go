@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTimeoutWithOpts(t *testing.T) {
+	var logs syncBuffer
+	lateWrite := make(chan error, 1)
+
+	r := chi.NewRouter()
+	r.Use(TimeoutWithOpts(TimeoutOpts{
+		Timeout: 20 * time.Millisecond,
+		Status:  http.StatusServiceUnavailable,
+		Logger:  log.New(&logs, "", 0),
+	}))
+	r.Get("/fast/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Id", chi.URLParam(r, "id"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("fast"))
+	})
+	r.Get("/slow/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		time.Sleep(50 * time.Millisecond)
+		_, err := w.Write([]byte("late"))
+		lateWrite <- err
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/fast/1", nil))
+	assertEqual(t, http.StatusCreated, w.Code)
+	assertEqual(t, "1", w.Header().Get("X-Id"))
+	assertEqual(t, "fast", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/slow/1", nil))
+	assertEqual(t, http.StatusServiceUnavailable, w.Code)
+	assertEqual(t, "Service Unavailable\n", w.Body.String())
+
+	assertEqual(t, http.ErrHandlerTimeout, <-lateWrite)
+	assertEqual(t, "Service Unavailable\n", w.Body.String())
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(logs.String(), "GET /slow/{id} returned after") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting the timed out handler to be logged, got %q", logs.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTimeoutWithOptsFlush(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(TimeoutWithOpts(TimeoutOpts{Timeout: 20 * time.Millisecond, Logger: log.New(&syncBuffer{}, "", 0)}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("flushed"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assertEqual(t, http.StatusOK, w.Code)
+	assertEqual(t, "flushed", w.Body.String())
+}
+
+func TestTimeoutWithOptsPanic(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Recoverer)
+	r.Use(TimeoutWithOpts(TimeoutOpts{Timeout: time.Second}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		panic("foo")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assertEqual(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestTimeoutWithOptsPanicAtDeadline(t *testing.T) {
+	var logs syncBuffer
+	r := chi.NewRouter()
+	r.Use(Recoverer)
+	r.Use(TimeoutWithOpts(TimeoutOpts{Timeout: time.Millisecond, Logger: log.New(&logs, "", 0)}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		panic("foo")
+	})
+
+	// The panic is either re-raised, or logged once the timeout is
+	// responded, but never lost.
+	timedOut := 0
+	for i := 0; i < 50; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code == http.StatusGatewayTimeout {
+			timedOut++
+		} else {
+			assertEqual(t, http.StatusInternalServerError, w.Code)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for strings.Count(logs.String(), "panicked after") != timedOut {
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting %d panics to be logged, got %q", timedOut, logs.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
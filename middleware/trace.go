@@ -0,0 +1,275 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	// SpanCtxKey is the context.Context key to store the server span of a
+	// request.
+	SpanCtxKey = &contextKey{"Span"}
+)
+
+// Span is the server span of a request, see the Trace middleware.
+type Span struct {
+	// TraceID is the 32 hex digits trace-id of the trace the span is part of.
+	TraceID string `json:"trace_id"`
+
+	// SpanID is the 16 hex digits id of the span.
+	SpanID string `json:"span_id"`
+
+	// ParentSpanID is the id of the span of the caller, if any.
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+
+	// TraceState is the vendor specific tracestate of the trace.
+	TraceState string `json:"trace_state,omitempty"`
+
+	// Sampled reports whether the trace is sampled, that is recorded.
+	Sampled bool `json:"sampled"`
+
+	// Name is the name of the span, the method and route pattern of the
+	// request, such as "GET /users/{id}".
+	Name string `json:"name"`
+
+	Method       string        `json:"method"`
+	Target       string        `json:"target"`
+	Start        time.Time     `json:"start"`
+	Duration     time.Duration `json:"duration"`
+	Status       int           `json:"status"`
+	BytesWritten int           `json:"bytes_written"`
+}
+
+// TraceParent returns the traceparent header of requests made on behalf of
+// the span, see SetTraceContext.
+func (s *Span) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// GetSpan returns the in-context server span of a request.
+func GetSpan(ctx context.Context) *Span {
+	span, _ := ctx.Value(SpanCtxKey).(*Span)
+	return span
+}
+
+// SetTraceContext sets the traceparent and tracestate headers of an outgoing
+// request, to propagate the trace of the in-context span.
+func SetTraceContext(ctx context.Context, header http.Header) {
+	span := GetSpan(ctx)
+	if span == nil {
+		return
+	}
+	header.Set("traceparent", span.TraceParent())
+	if span.TraceState != "" {
+		header.Set("tracestate", span.TraceState)
+	} else {
+		header.Del("tracestate")
+	}
+}
+
+// SpanExporter receives the finished spans of the Trace middleware.
+// Implementations must be safe for concurrent use.
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// MemorySpanExporter is a SpanExporter keeping the spans in memory, for
+// example in tests.
+type MemorySpanExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// ExportSpan records the span.
+func (e *MemorySpanExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans returns the recorded spans.
+func (e *MemorySpanExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset forgets the recorded spans.
+func (e *MemorySpanExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// JSONSpanExporter is a SpanExporter writing spans to an io.Writer, such
+// as os.Stdout, as lines of JSON.
+type JSONSpanExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSpanExporter returns a JSONSpanExporter writing to w.
+func NewJSONSpanExporter(w io.Writer) *JSONSpanExporter {
+	return &JSONSpanExporter{w: w}
+}
+
+// ExportSpan writes the span as a line of JSON.
+func (e *JSONSpanExporter) ExportSpan(span *Span) {
+	b, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	e.w.Write(append(b, '\n'))
+	e.mu.Unlock()
+}
+
+// TraceOpts represents a set of tracing options.
+type TraceOpts struct {
+	// Exporter receives the finished spans of sampled traces.
+	Exporter SpanExporter
+
+	// RequestID sets the request ID to the trace ID, when the request has no
+	// ID yet, so that GetReqID and the Logger report it.
+	RequestID bool
+}
+
+// Trace is a middleware that records a server span per request, and hands
+// it to the exporter when finished. See TraceWithOpts.
+func Trace(exporter SpanExporter) func(next http.Handler) http.Handler {
+	return TraceWithOpts(TraceOpts{Exporter: exporter})
+}
+
+// TraceWithOpts is a middleware that propagates W3C Trace Context headers and
+// records a server span per request, using passed TraceOpts.
+//
+// The span continues the trace of the traceparent and tracestate request
+// headers, or starts a new one. It's named after the method and the route
+// pattern of the request once routing is done, and records the status, the
+// bytes written and the duration of the response. The span is stored in the
+// request context, see GetSpan and SetTraceContext to propagate the trace to
+// other services.
+//
+// Trace should go before the Logger middleware for the log entries to report
+// the trace ID as request ID.
+func TraceWithOpts(opts TraceOpts) func(next http.Handler) http.Handler {
+	if opts.Exporter == nil {
+		panic("chi/middleware: Trace expects an exporter")
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			span := &Span{
+				SpanID:  randomHex(8),
+				Sampled: true,
+				Method:  r.Method,
+				Target:  r.URL.RequestURI(),
+				Start:   time.Now(),
+			}
+			if tp, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+				span.TraceID = tp.traceID
+				span.ParentSpanID = tp.parentID
+				span.Sampled = tp.sampled
+				span.TraceState = strings.Join(r.Header.Values("tracestate"), ",")
+			} else {
+				span.TraceID = randomHex(16)
+			}
+
+			ctx := context.WithValue(r.Context(), SpanCtxKey, span)
+			if opts.RequestID && GetReqID(ctx) == "" {
+				ctx = context.WithValue(ctx, RequestIDKey, span.TraceID)
+			}
+			ww := NewWrapResponseWriter(w, r.ProtoMajor)
+
+			finish := func(status int) {
+				span.Duration = time.Since(span.Start)
+				span.Status = status
+				span.BytesWritten = ww.BytesWritten()
+				span.Name = r.Method
+				if rctx := chi.RouteContext(r.Context()); rctx != nil {
+					if pattern := rctx.RoutePattern(); pattern != "" {
+						span.Name += " " + pattern
+					}
+				}
+				if span.Sampled {
+					opts.Exporter.ExportSpan(span)
+				}
+			}
+			defer func() {
+				if rvr := recover(); rvr != nil {
+					finish(http.StatusInternalServerError)
+					panic(rvr)
+				}
+			}()
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			finish(status)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+type traceParent struct {
+	traceID  string
+	parentID string
+	sampled  bool
+}
+
+// parseTraceParent parses a traceparent header of the form
+// "00-<trace-id>-<parent-id>-<flags>", see
+// https://www.w3.org/TR/trace-context/#traceparent-header.
+func parseTraceParent(header string) (traceParent, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || !isLowerHex(parts[0], 2) || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return traceParent{}, false
+	}
+	traceID, parentID, flags := parts[1], parts[2], parts[3]
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) ||
+		!isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) ||
+		!isLowerHex(flags, 2) {
+		return traceParent{}, false
+	}
+	b, _ := hex.DecodeString(flags)
+	return traceParent{traceID: traceID, parentID: parentID, sampled: b[0]&1 == 1}, true
+}
+
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes as hex digits.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("chi/middleware: failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestTrace(t *testing.T) {
+	exporter := &MemorySpanExporter{}
+
+	r := chi.NewRouter()
+	r.Use(TraceWithOpts(TraceOpts{Exporter: exporter, RequestID: true}))
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		header := http.Header{}
+		SetTraceContext(r.Context(), header)
+		w.Header().Set("X-Downstream", header.Get("traceparent"))
+		w.Write([]byte(GetReqID(r.Context())))
+	})
+
+	// A new trace
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expecting 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	assertEqual(t, "GET /users/{id}", span.Name)
+	assertEqual(t, 200, span.Status)
+	assertEqual(t, 32, span.BytesWritten)
+	assertEqual(t, span.TraceID, w.Body.String())
+	assertEqual(t, "", span.ParentSpanID)
+	if !regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`).MatchString(w.Header().Get("X-Downstream")) {
+		t.Fatalf("unexpected traceparent %q", w.Header().Get("X-Downstream"))
+	}
+
+	// A continued trace
+	exporter.Reset()
+	req = httptest.NewRequest("GET", "/users/2", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "congo=t61rcWkgMzE")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	span = exporter.Spans()[0]
+	assertEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+	assertEqual(t, "00f067aa0ba902b7", span.ParentSpanID)
+	assertEqual(t, "congo=t61rcWkgMzE", span.TraceState)
+	assertEqual(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanID+"-01", w.Header().Get("X-Downstream"))
+
+	// Unsampled traces aren't exported, and unrouted requests are named
+	// after their method.
+	exporter.Reset()
+	req = httptest.NewRequest("GET", "/users/3", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/nope", nil))
+
+	spans = exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expecting 1 span, got %d", len(spans))
+	}
+	assertEqual(t, "POST", spans[0].Name)
+	assertEqual(t, 404, spans[0].Status)
+}
+
+func TestParseTraceParent(t *testing.T) {
+	tests := map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":     true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-ext": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-ext": false,
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":     false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":     false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":     false,
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01":     false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7":        false,
+	}
+	for header, ok := range tests {
+		if _, got := parseTraceParent(header); got != ok {
+			t.Errorf("%s: expected %v, got %v", header, ok, got)
+		}
+	}
+}
+
+func TestJSONSpanExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := NewJSONSpanExporter(&buf)
+	exporter.ExportSpan(&Span{TraceID: "t", SpanID: "s", Name: "GET /"})
+	exporter.ExportSpan(&Span{TraceID: "t", SpanID: "s2", Name: "GET /"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertEqual(t, 2, len(lines))
+	var span Span
+	assertNoError(t, json.Unmarshal([]byte(lines[1]), &span))
+	assertEqual(t, "s2", span.SpanID)
+}
@@ -105,6 +105,12 @@ func (b *basicWriter) Status() int {
 	return b.code
 }
 
+// committed reports whether the response headers were sent by a Write
+// or Flush.
+func (b *basicWriter) committed() bool {
+	return b.wroteHeader
+}
+
 func (b *basicWriter) BytesWritten() int {
 	return b.bytes
 }
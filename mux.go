@@ -36,6 +36,11 @@ type Mux struct {
 	// Routing context pool
 	pool *sync.Pool
 
+	// Custom HTTP methods supported by the mux, in addition to the
+	// standard ones and those registered globally with RegisterMethod. The
+	// set is shared with the muxes mounted onto or along this one.
+	methods *methodSet
+
 	// Custom route not found handler
 	notFoundHandler http.HandlerFunc
 
@@ -48,7 +53,7 @@ type Mux struct {
 // NewMux returns a newly initialized Mux object that implements the Router
 // interface.
 func NewMux() *Mux {
-	mux := &Mux{tree: &node{}, pool: &sync.Pool{}}
+	mux := &Mux{tree: &node{}, pool: &sync.Pool{}, methods: &methodSet{}}
 	mux.pool.New = func() interface{} {
 		return NewRouteContext()
 	}
@@ -117,13 +122,28 @@ func (mx *Mux) HandleFunc(pattern string, handlerFn http.HandlerFunc) {
 // Method adds the route `pattern` that matches `method` http method to
 // execute the `handler` http.Handler.
 func (mx *Mux) Method(method, pattern string, handler http.Handler) {
-	m, ok := methodMap[strings.ToUpper(method)]
+	m, ok := mx.methodTyp(strings.ToUpper(method))
 	if !ok {
 		panic(fmt.Sprintf("chi: '%s' http method is not supported.", method))
 	}
 	mx.handle(m, pattern, handler)
 }
 
+// RegisterMethod adds support for custom HTTP methods to the mux, available
+// via Method and MethodFunc. Unlike the package-level RegisterMethod, the
+// methods are only supported by this mux, its inline groups, its sub-routers
+// and the routers it is mounted onto, whether they were mounted before or
+// after the methods were registered.
+//
+// Routes defined with Handle and HandleFunc match the custom methods as
+// well, regardless of whether they were defined before or after the method
+// was registered.
+func (mx *Mux) RegisterMethod(methods ...string) {
+	for _, m := range methods {
+		mx.methods.add(m)
+	}
+}
+
 // MethodFunc adds the route `pattern` that matches `method` http method to
 // execute the `handlerFn` http.HandlerFunc.
 func (mx *Mux) MethodFunc(method, pattern string, handlerFn http.HandlerFunc) {
@@ -241,7 +261,7 @@ func (mx *Mux) With(middlewares ...func(http.Handler) http.Handler) Router {
 	mws = append(mws, middlewares...)
 
 	im := &Mux{
-		pool: mx.pool, inline: true, parent: mx, tree: mx.tree, middlewares: mws,
+		pool: mx.pool, methods: mx.methods, inline: true, parent: mx, tree: mx.tree, middlewares: mws,
 		notFoundHandler: mx.notFoundHandler, methodNotAllowedHandler: mx.methodNotAllowedHandler,
 	}
 
@@ -267,6 +287,7 @@ func (mx *Mux) Route(pattern string, fn func(r Router)) Router {
 		panic(fmt.Sprintf("chi: attempting to Route() a nil subrouter on '%s'", pattern))
 	}
 	subRouter := NewRouter()
+	subRouter.methods.merge(mx.methods)
 	fn(subRouter)
 	mx.Mount(pattern, subRouter)
 	return subRouter
@@ -299,6 +320,12 @@ func (mx *Mux) Mount(pattern string, handler http.Handler) {
 		subr.MethodNotAllowed(mx.methodNotAllowedHandler)
 	}
 
+	// Share the custom methods between the mux and the sub-Router, so requests
+	// with those methods are routed through to the sub-Router.
+	if ok {
+		mx.methods.merge(subr.methods)
+	}
+
 	mountHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rctx := RouteContext(r.Context())
 
@@ -314,14 +341,19 @@ func (mx *Mux) Mount(pattern string, handler http.Handler) {
 		handler.ServeHTTP(w, r)
 	})
 
+	subroutes, _ := handler.(Routes)
+
 	if pattern == "" || pattern[len(pattern)-1] != '/' {
-		mx.handle(mALL|mSTUB, pattern, mountHandler)
-		mx.handle(mALL|mSTUB, pattern+"/", mountHandler)
+		// The stubs keep a reference to the sub-Router as well, so Match
+		// can continue searching there, just as the mountHandler would.
+		n := mx.handle(mALL|mSTUB, pattern, mountHandler)
+		n.subroutes = subroutes
+		n = mx.handle(mALL|mSTUB, pattern+"/", mountHandler)
+		n.subroutes = subroutes
 		pattern += "/"
 	}
 
 	method := mALL
-	subroutes, _ := handler.(Routes)
 	if subroutes != nil {
 		method |= mSTUB
 	}
@@ -335,7 +367,23 @@ func (mx *Mux) Mount(pattern string, handler http.Handler) {
 // Routes returns a slice of routing information from the tree,
 // useful for traversing available routes of a router.
 func (mx *Mux) Routes() []Route {
-	return mx.tree.routes()
+	routes := mx.tree.routes()
+
+	// Routes for all methods also handle the custom methods of the mux.
+	custom := append(mx.methods.names(), globalMethods.names()...)
+	for _, rt := range routes {
+		h, ok := rt.Handlers["*"]
+		if !ok {
+			continue
+		}
+		for _, m := range custom {
+			if _, ok := rt.Handlers[m]; !ok {
+				rt.Handlers[m] = h
+			}
+		}
+	}
+
+	return routes
 }
 
 // Middlewares returns a slice of middleware handler functions.
@@ -350,7 +398,7 @@ func (mx *Mux) Middlewares() Middlewares {
 // Note: the *Context state is updated during execution, so manage
 // the state carefully or make a NewRouteContext().
 func (mx *Mux) Match(rctx *Context, method, path string) bool {
-	m, ok := methodMap[method]
+	m, ok := mx.methodTyp(method)
 	if !ok {
 		return false
 	}
@@ -431,9 +479,9 @@ func (mx *Mux) routeHTTP(w http.ResponseWriter, r *http.Request) {
 	if rctx.RouteMethod == "" {
 		rctx.RouteMethod = r.Method
 	}
-	method, ok := methodMap[rctx.RouteMethod]
+	method, ok := mx.methodTyp(rctx.RouteMethod)
 	if !ok {
-		mx.MethodNotAllowedHandler().ServeHTTP(w, r)
+		methodNotImplementedHandler(w, r)
 		return
 	}
 
@@ -449,6 +497,18 @@ func (mx *Mux) routeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// methodTyp returns the method type of a standard or custom HTTP method
+// supported by the mux.
+func (mx *Mux) methodTyp(method string) (methodTyp, bool) {
+	if m, ok := methodMap[method]; ok {
+		return m, true
+	}
+	if m, ok := mx.methods.get(method); ok {
+		return m, true
+	}
+	return globalMethods.get(method)
+}
+
 func (mx *Mux) nextRoutePath(rctx *Context) string {
 	routePath := "/"
 	nx := len(rctx.routeParams.Keys) - 1 // index of last param in list
@@ -483,3 +543,11 @@ func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(405)
 	w.Write(nil)
 }
+
+// methodNotImplementedHandler is a helper function to respond with a 501,
+// not implemented, for HTTP methods unknown to the router. See RFC 7231,
+// section 6.6.2.
+func methodNotImplementedHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(501)
+	w.Write(nil)
+}
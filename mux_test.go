@@ -201,9 +201,9 @@ func TestMuxBasic(t *testing.T) {
 		t.Error("expecting response body: 'catchall'")
 	}
 
-	// Custom http method DIE /ping/1/woop
-	if resp, body := testRequest(t, ts, "DIE", "/ping/1/woop", nil); body != "" || resp.StatusCode != 405 {
-		t.Fatalf(fmt.Sprintf("expecting 405 status and empty body, got %d '%s'", resp.StatusCode, body))
+	// Unknown http method DIE /ping/1/woop
+	if resp, body := testRequest(t, ts, "DIE", "/ping/1/woop", nil); body != "" || resp.StatusCode != 501 {
+		t.Fatalf(fmt.Sprintf("expecting 501 status and empty body, got %d '%s'", resp.StatusCode, body))
 	}
 }
 
@@ -1656,6 +1656,122 @@ func TestCustomHTTPMethod(t *testing.T) {
 	}
 }
 
+func TestMuxRegisterMethod(t *testing.T) {
+	r := NewRouter()
+
+	// routes for all methods match custom methods registered afterwards
+	r.HandleFunc("/all", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("all:" + r.Method))
+	})
+
+	// no limit on the number of custom methods
+	for i := 0; i < 100; i++ {
+		r.RegisterMethod(fmt.Sprintf("VERB%d", i))
+	}
+	r.RegisterMethod("PROPFIND", "mkcol")
+
+	r.MethodFunc("PROPFIND", "/dav", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("propfind"))
+	})
+	r.MethodFunc("VERB99", "/dav", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("verb99"))
+	})
+	r.Route("/sub", func(r Router) {
+		r.MethodFunc("MKCOL", "/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("sub:mkcol"))
+		})
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "PROPFIND", "/dav", nil); body != "propfind" {
+		t.Fatalf(body)
+	}
+	if _, body := testRequest(t, ts, "VERB99", "/dav", nil); body != "verb99" {
+		t.Fatalf(body)
+	}
+	if resp, _ := testRequest(t, ts, "MKCOL", "/dav", nil); resp.StatusCode != 405 {
+		t.Fatalf("expecting 405 status, got %d", resp.StatusCode)
+	}
+	if _, body := testRequest(t, ts, "MKCOL", "/all", nil); body != "all:MKCOL" {
+		t.Fatalf(body)
+	}
+	if _, body := testRequest(t, ts, "MKCOL", "/sub", nil); body != "sub:mkcol" {
+		t.Fatalf(body)
+	}
+	if resp, _ := testRequest(t, ts, "UNKNOWN", "/all", nil); resp.StatusCode != 501 {
+		t.Fatalf("expecting 501 status, got %d", resp.StatusCode)
+	}
+
+	// custom methods are not shared with other routers
+	r2 := NewRouter()
+	r2.HandleFunc("/all", func(w http.ResponseWriter, r *http.Request) {})
+	if resp, _ := testHandler(t, r2, "PROPFIND", "/all", nil); resp.StatusCode != 501 {
+		t.Fatalf("expecting 501 status, got %d", resp.StatusCode)
+	}
+	if r2.Match(NewRouteContext(), "PROPFIND", "/all") {
+		t.Fatal("not expecting to find match for route:", "PROPFIND", "/all")
+	}
+
+	found := false
+	Walk(r, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if method == "MKCOL" && route == "/all" {
+			found = true
+		}
+		return nil
+	})
+	if !found {
+		t.Fatal("expecting Walk to visit custom method MKCOL on /all")
+	}
+}
+
+func TestMuxRegisterMethodAfterMount(t *testing.T) {
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + ":" + r.Method))
+		}
+	}
+
+	// method registered on a sub-router after it was mounted
+	r := NewRouter()
+	dav := NewRouter()
+	r.Mount("/dav", dav)
+	dav.RegisterMethod("PROPFIND")
+	dav.MethodFunc("PROPFIND", "/", handler("dav"))
+
+	// grandchild with its own method mounted onto a mounted child
+	api := NewRouter()
+	r.Mount("/api", api)
+	rpc := NewRouter()
+	rpc.RegisterMethod("INVOKE")
+	rpc.MethodFunc("INVOKE", "/call", handler("rpc"))
+	api.Mount("/rpc", rpc)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "PROPFIND", "/dav", nil); body != "dav:PROPFIND" {
+		t.Fatalf(body)
+	}
+	if _, body := testRequest(t, ts, "INVOKE", "/api/rpc/call", nil); body != "rpc:INVOKE" {
+		t.Fatalf(body)
+	}
+	if resp, _ := testRequest(t, ts, "INVOKE", "/dav", nil); resp.StatusCode != 405 {
+		t.Fatalf("expecting 405 status, got %d", resp.StatusCode)
+	}
+	if resp, _ := testRequest(t, ts, "MKCOL", "/dav", nil); resp.StatusCode != 501 {
+		t.Fatalf("expecting 501 status, got %d", resp.StatusCode)
+	}
+
+	// the methods stay private to the mounted routers
+	other := NewRouter()
+	other.HandleFunc("/", handler("other"))
+	if resp, _ := testHandler(t, other, "PROPFIND", "/", nil); resp.StatusCode != 501 {
+		t.Fatalf("expecting 501 status, got %d", resp.StatusCode)
+	}
+}
+
 func TestMuxMatch(t *testing.T) {
 	r := NewRouter()
 	r.Get("/hi", func(w http.ResponseWriter, r *http.Request) {
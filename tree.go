@@ -9,8 +9,8 @@ import (
 	"net/http"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 )
 
 type methodTyp uint
@@ -28,7 +28,13 @@ const (
 	mTRACE
 )
 
-var mALL = mCONNECT | mDELETE | mGET | mHEAD |
+// mCUSTOM is the method type of the first custom HTTP method. Custom method
+// types are multiples of mCUSTOM, which keeps the low bits free for the
+// standard method flags above and leaves no practical limit on the number
+// of custom methods.
+const mCUSTOM = mTRACE << 1
+
+const mALL = mCONNECT | mDELETE | mGET | mHEAD |
 	mOPTIONS | mPATCH | mPOST | mPUT | mTRACE
 
 var methodMap = map[string]methodTyp{
@@ -43,9 +49,62 @@ var methodMap = map[string]methodTyp{
 	http.MethodTrace:   mTRACE,
 }
 
-// RegisterMethod adds support for custom HTTP method handlers, available
-// via Router#Method and Router#MethodFunc
+// customMethodTyps interns the method types of custom HTTP methods, so a
+// method has the same type across every router in the process.
+var customMethodTyps = struct {
+	sync.RWMutex
+	typs  map[string]methodTyp
+	names map[methodTyp]string
+}{typs: map[string]methodTyp{}, names: map[methodTyp]string{}}
+
+// globalMethods is the set of custom HTTP methods registered with
+// RegisterMethod, which are supported by every router.
+var globalMethods = &methodSet{}
+
+// RegisterMethod adds support for custom HTTP method handlers to every
+// router in the process, available via Router#Method and Router#MethodFunc.
+//
+// To support a custom method on a single router only, use Mux#RegisterMethod.
 func RegisterMethod(method string) {
+	globalMethods.add(method)
+}
+
+// internMethod returns the method type for a custom HTTP method, allocating
+// a new one the first time the method is seen.
+func internMethod(method string) methodTyp {
+	customMethodTyps.Lock()
+	defer customMethodTyps.Unlock()
+	if mt, ok := customMethodTyps.typs[method]; ok {
+		return mt
+	}
+	mt := mCUSTOM * methodTyp(len(customMethodTyps.typs)+1)
+	customMethodTyps.typs[method] = mt
+	customMethodTyps.names[mt] = method
+	return mt
+}
+
+// methodSets guards every methodSet, as merging sets links them together.
+var methodSets sync.RWMutex
+
+// methodSet is a concurrency-safe set of custom HTTP methods supported by
+// a router. Sets of routers mounted onto each other are merged, so they all
+// support the same methods whatever the order of registration.
+type methodSet struct {
+	typs map[string]methodTyp
+
+	// merged is the set this one was merged into, if any.
+	merged *methodSet
+}
+
+// root returns the set holding the methods, following merged sets.
+func (ms *methodSet) root() *methodSet {
+	for ms.merged != nil {
+		ms = ms.merged
+	}
+	return ms
+}
+
+func (ms *methodSet) add(method string) {
 	if method == "" {
 		return
 	}
@@ -53,13 +112,51 @@ func RegisterMethod(method string) {
 	if _, ok := methodMap[method]; ok {
 		return
 	}
-	n := len(methodMap)
-	if n > strconv.IntSize-2 {
-		panic(fmt.Sprintf("chi: max number of methods reached (%d)", strconv.IntSize))
+	mt := internMethod(method)
+
+	methodSets.Lock()
+	defer methodSets.Unlock()
+	root := ms.root()
+	if root.typs == nil {
+		root.typs = make(map[string]methodTyp)
+	}
+	root.typs[method] = mt
+}
+
+// merge joins the methods of both sets into a single one.
+func (ms *methodSet) merge(other *methodSet) {
+	methodSets.Lock()
+	defer methodSets.Unlock()
+	a, b := ms.root(), other.root()
+	if a == b {
+		return
+	}
+	if a.typs == nil {
+		a.typs = make(map[string]methodTyp, len(b.typs))
 	}
-	mt := methodTyp(2 << n)
-	methodMap[method] = mt
-	mALL |= mt
+	for m, mt := range b.typs {
+		a.typs[m] = mt
+	}
+	b.typs = nil
+	b.merged = a
+}
+
+func (ms *methodSet) get(method string) (methodTyp, bool) {
+	methodSets.RLock()
+	defer methodSets.RUnlock()
+	mt, ok := ms.root().typs[method]
+	return mt, ok
+}
+
+func (ms *methodSet) names() []string {
+	methodSets.RLock()
+	defer methodSets.RUnlock()
+	typs := ms.root().typs
+	names := make([]string, 0, len(typs))
+	for m := range typs {
+		names = append(names, m)
+	}
+	return names
 }
 
 type nodeTyp uint8
@@ -113,6 +210,19 @@ type endpoint struct {
 	paramKeys []string
 }
 
+// find returns the endpoint for a method. Custom methods fall back to the
+// endpoint registered for all methods, as they aren't known to the tree at
+// the time such routes are inserted.
+func (s endpoints) find(method methodTyp) *endpoint {
+	if h, ok := s[method]; ok {
+		return h
+	}
+	if method >= mCUSTOM {
+		return s[mALL]
+	}
+	return nil
+}
+
 func (s endpoints) Value(method methodTyp) *endpoint {
 	mh, ok := s[method]
 	if !ok {
@@ -374,12 +484,13 @@ func (n *node) FindRoute(rctx *Context, method methodTyp, path string) (*node, e
 	rctx.URLParams.Values = append(rctx.URLParams.Values, rctx.routeParams.Values...)
 
 	// Record the routing pattern in the request lifecycle
-	if rn.endpoints[method].pattern != "" {
-		rctx.routePattern = rn.endpoints[method].pattern
+	h := rn.endpoints.find(method)
+	if h.pattern != "" {
+		rctx.routePattern = h.pattern
 		rctx.RoutePatterns = append(rctx.RoutePatterns, rctx.routePattern)
 	}
 
-	return rn, rn.endpoints, rn.endpoints[method].handler
+	return rn, rn.endpoints, h.handler
 }
 
 // Recursive edge traversal by checking all nodeTyp groups along the way.
@@ -448,7 +559,7 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 
 				if len(xsearch) == 0 {
 					if xn.isLeaf() {
-						h := xn.endpoints[method]
+						h := xn.endpoints.find(method)
 						if h != nil && h.handler != nil {
 							rctx.routeParams.Keys = append(rctx.routeParams.Keys, h.paramKeys...)
 							return xn
@@ -487,7 +598,7 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 		// did we find it yet?
 		if len(xsearch) == 0 {
 			if xn.isLeaf() {
-				h := xn.endpoints[method]
+				h := xn.endpoints.find(method)
 				if h != nil && h.handler != nil {
 					rctx.routeParams.Keys = append(rctx.routeParams.Keys, h.paramKeys...)
 					return xn
@@ -595,7 +706,10 @@ func (n *node) routes() []Route {
 	rts := []Route{}
 
 	n.walk(func(eps endpoints, subroutes Routes) bool {
-		if eps[mSTUB] != nil && eps[mSTUB].handler != nil && subroutes == nil {
+		// Skip the stubs of mounted handlers, only the wildcard route of a
+		// mount is reported.
+		if eps[mSTUB] != nil && eps[mSTUB].handler != nil &&
+			(subroutes == nil || !strings.HasSuffix(eps[mALL].pattern, "*")) {
 			return false
 		}
 
@@ -770,7 +884,9 @@ func methodTypString(method methodTyp) string {
 			return s
 		}
 	}
-	return ""
+	customMethodTyps.RLock()
+	defer customMethodTyps.RUnlock()
+	return customMethodTyps.names[method]
 }
 
 type nodes []*node